@@ -81,10 +81,15 @@ func main() {
 	}
 
 	fmt.Printf("syscall.Exec('%s', '%s', '%s')\n", programFlag, argv, envFlag)
+	// on success the program takes over this process, so its exit status is ours
 	err := syscall.Exec(programFlag, argv, os.Environ())
-	if err != nil {
-		fmt.Printf("exec failed: %s\n", err)
+	fmt.Printf("exec failed: %s\n", err)
+
+	// same codes a shell uses for a missing or unrunnable command
+	if err == syscall.ENOENT {
+		os.Exit(127)
 	}
+	os.Exit(126)
 }
 
 // vim: ts=4 sw=4 noet tw=120 softtabstop=4
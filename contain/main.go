@@ -4,8 +4,178 @@
 
 package main
 
+import (
+	"../src/lnxns"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+type envList []string
+
+func (env *envList) String() string {
+	return fmt.Sprint(*env)
+}
+
+func (env *envList) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("'%s' is not key=value", value)
+	}
+	*env = append(*env, value)
+	return nil
+}
+
+var rootFlag string
+var imageFlag, refFlag, cacheFlag string
+var overlayDir string
+var cgroupName string
+var cgRoot string
+var hostnameFlag string
+var rootlessFlag, initFlag bool
+var envFlag envList
+var volumesFlag lnxns.VolumeList
+
+func init() {
+	flag.StringVar(&rootFlag, "root", "", "root filesystem of the container, the host's / is shared when empty")
+	flag.StringVar(&imageFlag, "image", "", "OCI image layout or docker save directory to run, instead of -root")
+	flag.StringVar(&refFlag, "ref", "", "with -image, the tag or name of the image when there is more than one")
+	flag.StringVar(&cacheFlag, "cache", lnxns.RootfsCacheDir, "directory to unpack images into")
+	flag.StringVar(&cgroupName, "name", "", "cgroup to run the container in, created if needed, none when empty")
+	flag.StringVar(&cgRoot, "cg_root", "/sys/fs/cgroup", "path to where cgroups are mounted")
+	flag.StringVar(&hostnameFlag, "hostname", "", "hostname of the container")
+	flag.BoolVar(&rootlessFlag, "rootless", os.Geteuid() != 0, "run in a user namespace without real root, default for non-root users")
+	flag.BoolVar(&initFlag, "init", false, "run the command under a tiny init that reaps zombies and forwards signals")
+	flag.Var(&envFlag, "env", "key=value environment variables, can be repeated")
+	flag.Var(&volumesFlag, "v", "bind a host path into the container, host:container[:ro], can be repeated")
+}
+
+// run a command in new namespaces and optionally a cgroup, e.g.
+// contain -root /srv/build -v $PWD:/src -v /opt/toolchain:/opt/toolchain:ro -- make -C /src
+// contain -image ./alpine-oci -ref latest -- /bin/sh
 func main() {
-	return
+	lnxns.Init()
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 && imageFlag == "" {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] program [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	// pretend to be LXC like the cgroup utility, systemd and friends look for this
+	env := append(os.Environ(), "container=lxc")
+	if imageFlag != "" {
+		// an image brings its own environment
+		env = []string{"container=lxc"}
+	}
+
+	cfg := lnxns.Config{
+		Env:        append(env, envFlag...),
+		Root:       rootFlag,
+		Dev:        rootFlag != "",
+		Volumes:    volumesFlag,
+		Namespaces: lnxns.DefaultNamespaceSpec(),
+		Hostname:   hostnameFlag,
+		Init:       initFlag,
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+	}
+	cfg.Namespaces.Cgroup = lnxns.NewNamespace()
+
+	if len(args) > 0 {
+		cfg.Path = args[0]
+		cfg.Args = args
+	}
+
+	if imageFlag != "" {
+		var err error
+		if overlayDir, err = imageRoot(&cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "could not set up image %s: %s\n", imageFlag, err)
+			os.Exit(1)
+		}
+	}
+
+	if rootlessFlag {
+		if _, err := cfg.SetRootless(); err != nil {
+			fmt.Fprintf(os.Stderr, "rootless setup failed: %s\n", err)
+			exit(1)
+		}
+	}
+
+	if cgroupName != "" {
+		vfs, err := lnxns.NewVfs(cgRoot)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cgRoot, err)
+			exit(1)
+		}
+		if iscg, err := vfs.IsCgroupFs(); !iscg {
+			fmt.Fprintf(os.Stderr, "%s does not appear to be a cgroup filesystem: %s\n", cgRoot, err)
+			exit(1)
+		}
+		cfg.Cgroup, _ = lnxns.NewCgroup(vfs, cgroupName)
+	}
+
+	container, err := lnxns.Launch(&cfg)
+	if errors.Is(err, syscall.EINVAL) {
+		fmt.Fprintf(os.Stderr, "OS returned EINVAL. Make sure your kernel configuration includes all CONFIG_*_NS options.\n")
+		exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "could not start the container: %s\n", err)
+		exit(1)
+	}
+
+	res, err := container.Wait()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "waiting for the container failed: %s\n", err)
+		exit(1)
+	}
+
+	// exit like a shell would, 128+signal when the command was killed
+	exit(res.Status())
+}
+
+// os.Exit skips deferred calls, so the image's overlay is removed here
+func exit(code int) {
+	if overlayDir != "" {
+		os.RemoveAll(overlayDir)
+	}
+	os.Exit(code)
+}
+
+// unpack the image into the cache and run it on a throwaway overlay, so the
+// unpacked image stays pristine for the next container. Returns the overlay's directory.
+func imageRoot(cfg *lnxns.Config) (string, error) {
+	img, err := lnxns.OpenImage(imageFlag, refFlag)
+	if err != nil {
+		return "", err
+	}
+
+	rootfs, err := img.Unpack(cacheFlag)
+	if err != nil {
+		return "", err
+	}
+
+	if err = cfg.SetImage(rootfs, &img.Config); err != nil {
+		return "", err
+	}
+
+	dir, err := ioutil.TempDir("", "contain-")
+	if err != nil {
+		return "", err
+	}
+	cfg.Overlay = lnxns.NewOverlay(dir, rootfs)
+	cfg.Overlay.Discard = true
+	cfg.Root = path.Join(dir, "merged")
+	cfg.Dev = true
+
+	return dir, nil
 }
 
 // vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,54 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"../src/lnxns"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var cacheFlag string
+var imageFlag bool
+var refFlag string
+
+func init() {
+	flag.StringVar(&cacheFlag, "cache", lnxns.RootfsCacheDir, "directory to unpack root filesystems into")
+	flag.BoolVar(&imageFlag, "image", false, "the argument is an OCI image layout or docker save directory")
+	flag.StringVar(&refFlag, "ref", "", "with -image, the tag or name of the image when there is more than one")
+}
+
+// unpack a rootfs tarball or an image into the cache and print its path, e.g.
+// ./nschroot $(./mkrootfs alpine-minirootfs.tar.gz) /bin/sh
+// ./nschroot $(./mkrootfs -image -ref latest ./alpine-oci) /bin/sh
+func main() {
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s [-cache dir] rootfs.tar[.gz] | -image [-ref ref] dir\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	var rootfs string
+	var err error
+	if imageFlag {
+		var img *lnxns.Image
+		if img, err = lnxns.OpenImage(args[0], refFlag); err == nil {
+			rootfs, err = img.Unpack(cacheFlag)
+		}
+	} else {
+		rootfs, err = lnxns.UnpackRootfs(cacheFlag, args[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not unpack %s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+
+	fmt.Println(rootfs)
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -6,23 +6,56 @@ package main
 
 import (
 	"../src/lnxns"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"syscall"
+	"time"
 )
 
+var rootlessFlag bool
+var boottimeFlag, monotonicFlag time.Duration
+var hostnameFlag, domainnameFlag string
+var initFlag, devFlag bool
+var volumesFlag lnxns.VolumeList
+var overlayFlag string
+var discardFlag bool
+
+func init() {
+	flag.BoolVar(&rootlessFlag, "rootless", os.Geteuid() != 0, "run in a user namespace without real root, default for non-root users")
+	flag.DurationVar(&boottimeFlag, "boottime", 0, "shift the container's boot time clock (and uptime), e.g. 240h")
+	flag.DurationVar(&monotonicFlag, "monotonic", 0, "shift the container's monotonic clock")
+	flag.StringVar(&hostnameFlag, "hostname", "", "hostname of the container")
+	flag.StringVar(&domainnameFlag, "domainname", "", "NIS domain name of the container")
+	flag.BoolVar(&initFlag, "init", false, "run the command under a tiny init that reaps zombies and forwards signals")
+	flag.BoolVar(&devFlag, "dev", true, "give the container its own minimal /dev instead of the one in the root")
+	flag.StringVar(&overlayFlag, "overlay", "", "leave the root untouched and keep the container's changes in this directory")
+	flag.BoolVar(&discardFlag, "discard", false, "with -overlay, throw the container's changes away when it exits")
+	flag.Var(&volumesFlag, "v", "bind a host path into the container, host:container[:ro], can be repeated")
+}
+
 func main() {
+	lnxns.Init()
+	flag.Parse()
+
 	var root, cmd string
 	var opts []string
 
-	if len(os.Args) < 3 {
+	args := flag.Args()
+	if len(args) < 2 {
 		panic("not enough arguments\n")
 	}
 
-	root = os.Args[1]
-	cmd = os.Args[2]
-	if len(os.Args) > 3 {
-		opts = os.Args[3:len(os.Args)]
+	root, err := filepath.Abs(args[0])
+	if err != nil {
+		panic(fmt.Sprintf("Could not make '%s' absolute: %s", args[0], err))
+	}
+	cmd = args[1]
+	if len(args) > 2 {
+		opts = args[2:]
 	}
 
 	rs, err := os.Stat(root)
@@ -40,36 +73,65 @@ func main() {
 
 	fmt.Printf("root: %s, cmd: %s, opts: %s\n", root, cmd, opts)
 
-	err = os.Chdir(root)
-	if err != nil {
-		panic(fmt.Sprintf("chdir failed: %s", err))
+	// the chroot happens inside the new namespaces, the launcher itself stays put
+	cfg := lnxns.Config{
+		Path:       cmd,
+		Args:       append([]string{cmd}, opts...),
+		Root:       root,
+		Dev:        devFlag,
+		Volumes:    volumesFlag,
+		Namespaces: lnxns.DefaultNamespaceSpec(),
+		Hostname:   hostnameFlag,
+		Domainname: domainnameFlag,
+		Init:       initFlag,
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
 	}
 
-	err = syscall.Chroot(root)
-	if err != nil {
-		panic(fmt.Sprintf("chroot failed: %s", err))
+	// the root becomes the lower layer of an overlay mounted on overlay/merged
+	if overlayFlag != "" {
+		if overlayFlag, err = filepath.Abs(overlayFlag); err != nil {
+			panic(fmt.Sprintf("Could not make '%s' absolute: %s", overlayFlag, err))
+		}
+		cfg.Overlay = lnxns.NewOverlay(overlayFlag, root)
+		cfg.Overlay.Discard = discardFlag
+		cfg.Root = path.Join(overlayFlag, "merged")
 	}
 
-	// we're going to exec right away in the child, CLONE_VFORK will block the
-	// parent from being scheduled until the child starts up, see clone(2)
-	pid, err := lnxns.NsFork(lnxns.CLONE_VFORK)
+	// hide the host's cgroup paths from the container
+	cfg.Namespaces.Cgroup = lnxns.NewNamespace()
 
-	if err == syscall.EINVAL {
-		panic("OS returned EINVAL. Make sure your kernel configuration includes all CONFIG_*_NS options.")
-	} else if err != nil {
-		panic(fmt.Sprintf("lnxns.NsFork() failed: %s", err))
+	if boottimeFlag != 0 || monotonicFlag != 0 {
+		cfg.Namespaces.Time = lnxns.NewNamespace()
+		cfg.TimeOffsets = lnxns.TimeOffsets{Monotonic: monotonicFlag, Boottime: boottimeFlag}
 	}
 
-	if pid != 0 {
-		proc, _ := os.FindProcess(pid)
-		proc.Wait()
-	} else {
-		err = syscall.Exec(cmd, opts, os.Environ())
+	if rootlessFlag {
+		delegated, err := cfg.SetRootless()
 		if err != nil {
-			panic(fmt.Sprintf("exec failed: %s", err))
+			panic(fmt.Sprintf("rootless setup failed: %s", err))
+		}
+		if !delegated {
+			fmt.Printf("no ranges for this user in %s and %s, only root is mapped\n", lnxns.SubUIDFile, lnxns.SubGIDFile)
 		}
-		panic("impossible")
 	}
+
+	container, err := lnxns.Launch(&cfg)
+
+	if errors.Is(err, syscall.EINVAL) {
+		panic("OS returned EINVAL. Make sure your kernel configuration includes all CONFIG_*_NS options.")
+	} else if err != nil {
+		panic(fmt.Sprintf("lnxns.Launch() failed: %s", err))
+	}
+
+	res, err := container.Wait()
+	if err != nil {
+		panic(fmt.Sprintf("waiting for the container failed: %s", err))
+	}
+
+	// exit like a shell would, 128+signal when the command was killed
+	os.Exit(res.Status())
 }
 
 // vim: ts=4 sw=4 noet tw=120 softtabstop=4
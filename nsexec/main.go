@@ -0,0 +1,109 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"../src/lnxns"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// -ns type=path, may be given more than once
+type nsFlags map[string]string
+
+func (n nsFlags) String() string {
+	var parts []string
+	for t, p := range n {
+		parts = append(parts, t+"="+p)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (n nsFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("'%s' is not type=path", value)
+	}
+	n[parts[0]] = parts[1]
+	return nil
+}
+
+var pidFlag int
+var nsFlag = nsFlags{}
+
+func init() {
+	flag.IntVar(&pidFlag, "pid", 0, "join all namespaces of this pid, e.g. a container's init")
+	flag.Var(nsFlag, "ns", "join the namespace at a path, e.g. -ns net=/run/netns/blue (repeatable)")
+}
+
+func main() {
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s [-pid N] [-ns type=path ...] cmd [args...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "user namespaces can't be joined, so a rootless container can only be entered as real root\n")
+		os.Exit(2)
+	}
+
+	spec := &lnxns.NamespaceSpec{}
+	if pidFlag > 0 {
+		var err error
+		if spec, err = lnxns.JoinAllNamespaces(pidFlag); err != nil {
+			panic(fmt.Sprintf("could not read the namespaces of pid %d: %s", pidFlag, err))
+		}
+	}
+
+	for t, p := range nsFlag {
+		ns := lnxns.JoinNamespace(p)
+		switch t {
+		case "mnt":
+			spec.Mount = ns
+		case "pid":
+			spec.Pid = ns
+		case "uts":
+			spec.Uts = ns
+		case "ipc":
+			spec.Ipc = ns
+		case "net":
+			spec.Net = ns
+		case "cgroup":
+			spec.Cgroup = ns
+		case "user":
+			// setns(2) refuses to move a multithreaded process, which every Go program is,
+			// into a user namespace
+			panic("joining a user namespace is not supported, run nsexec as root instead")
+		default:
+			panic(fmt.Sprintf("unknown or unsupported namespace type '%s'", t))
+		}
+	}
+
+	// not exec.Command, which would look the program up on the host instead of in the namespace
+	cmd := &exec.Cmd{
+		Path:   args[0],
+		Args:   args,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	if err := lnxns.StartInNamespaces(spec, cmd); err != nil {
+		panic(fmt.Sprintf("could not start '%s': %s", args[0], err))
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			panic(err)
+		}
+	}
+
+	// exit like a shell would, 128+signal when the command was killed
+	os.Exit(lnxns.ProcessResult(cmd.ProcessState).Status())
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,120 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// where cgroup hierarchies are mounted, on the host and in the container
+const cgroupMountDir = "/sys/fs/cgroup"
+
+// called in the init stage once the parent has moved us into Config.Cgroup. A cgroup
+// namespace is rooted at the cgroup its creator is in at the time, which is why
+// the launcher doesn't let clone(2) create it.
+func unshareCgroupNs() error {
+	if err := syscall.Unshare(CLONE_NEWCGROUP); err != nil {
+		return fmt.Errorf("unshare CLONE_NEWCGROUP: %s", err)
+	}
+	return nil
+}
+
+// mount fresh copies of the host's cgroup hierarchies under root/sys/fs/cgroup. Mounted
+// from inside a cgroup namespace they show the container's group as their root, the
+// host's mounts would still show the whole tree. Must run in a new mount namespace.
+func mountCgroupFs(root string) error {
+	target, err := resolveInRoot(root, cgroupMountDir)
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(target); err != nil || !st.IsDir() {
+		// nowhere to mount them, e.g. a minimal rootfs without /sys
+		return nil
+	}
+
+	mounts, err := ProcFs().GetMountInfo("self/mountinfo")
+	if err != nil {
+		return err
+	}
+
+	var hierarchies []*MountInfo
+	for _, m := range mounts {
+		if m.Filesystem != "cgroup" && m.Filesystem != "cgroup2" {
+			continue
+		}
+		if m.Mountpoint == cgroupMountDir || strings.HasPrefix(m.Mountpoint, cgroupMountDir+"/") {
+			hierarchies = append(hierarchies, m)
+		}
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+
+	// a pure cgroup2 host mounts the hierarchy right on /sys/fs/cgroup, otherwise
+	// there is a tmpfs with one directory per hierarchy and symlinks like cpu -> cpu,cpuacct
+	if len(hierarchies) == 1 && hierarchies[0].Mountpoint == cgroupMountDir {
+		return mountCgroupHierarchy(hierarchies[0], target, flags)
+	} else if len(hierarchies) == 0 {
+		return nil
+	}
+
+	// read the symlinks first, with root at / the tmpfs goes right over them
+	links := make(map[string]string)
+	entries, _ := ioutil.ReadDir(cgroupMountDir)
+	for _, e := range entries {
+		if e.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if dest, err := os.Readlink(path.Join(cgroupMountDir, e.Name())); err == nil {
+			links[e.Name()] = dest
+		}
+	}
+
+	if err = syscall.Mount("tmpfs", target, "tmpfs", flags, "mode=755"); err != nil {
+		return fmt.Errorf("could not mount tmpfs on %s: %s", target, err)
+	}
+
+	for _, m := range hierarchies {
+		dir, err := resolveInRoot(root, m.Mountpoint)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err = mountCgroupHierarchy(m, dir, flags); err != nil {
+			return err
+		}
+	}
+
+	for name, dest := range links {
+		os.Symlink(dest, path.Join(target, name))
+	}
+
+	return nil
+}
+
+// mount one hierarchy, for cgroup v1 the super options name its controllers
+// e.g. rw,cpu,cpuacct or rw,xattr,name=systemd
+func mountCgroupHierarchy(m *MountInfo, target string, flags uintptr) error {
+	var opts []string
+	for _, o := range m.SuperOptions {
+		if o != "rw" && o != "ro" {
+			opts = append(opts, o)
+		}
+	}
+
+	err := syscall.Mount(m.Filesystem, target, m.Filesystem, flags, strings.Join(opts, ","))
+	if err != nil {
+		return fmt.Errorf("could not mount %s on %s: %s", m.Filesystem, target, err)
+	}
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,74 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCgroupNamespace(t *testing.T) {
+	spec := &lnxns.NamespaceSpec{Mount: lnxns.NewNamespace(), Cgroup: lnxns.NewNamespace()}
+
+	// the container is at the root of every hierarchy, v1 or v2, and the remounted
+	// hierarchies are rooted at its group. The host's are still in mountinfo below
+	// ours, only the last mount on each mountpoint counts.
+	var out bytes.Buffer
+	script := "cut -d: -f3 /proc/self/cgroup; " +
+		"awk '/ - cgroup2? / { root[$5] = $4 } END { for (m in root) print root[m] }' /proc/self/mountinfo"
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:       "sh",
+		Args:       []string{"sh", "-c", script},
+		Namespaces: spec,
+		Stdout:     &out,
+	})
+	if res, err := c.Wait(); err != nil || !res.Success() {
+		t.Fatalf("the container failed: %v, %v", res, err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line != "/" {
+			t.Fatalf("cgroupfs was not remounted for the container: '%s'", out.String())
+		}
+	}
+
+	vfs := lnxns.FindCgroupVfs()
+	if vfs == nil {
+		t.Skip("no cgroup v1 hierarchies to test with")
+	}
+
+	// a container launched into a cgroup sees it as its root
+	cg, err := lnxns.NewCgroup(vfs, "lnxns-cgroupns-test")
+	if err != nil {
+		t.Fatalf("NewCgroup failed: %s", err)
+	}
+	defer cg.Destroy()
+
+	out.Reset()
+	c = launchOrSkip(t, &lnxns.Config{
+		Path:       "sh",
+		Args:       []string{"sh", "-c", "cat /proc/self/cgroup; exec cat"},
+		Namespaces: spec,
+		Cgroup:     cg,
+		Stdin:      strings.NewReader(""),
+		Stdout:     &out,
+	})
+	host, _ := ioutil.ReadFile(path.Join("/proc", itoa(uint64(c.Pid)), "cgroup"))
+	c.Wait()
+
+	if !strings.Contains(string(host), "/lnxns-cgroupns-test\n") {
+		t.Fatalf("the container was not put in its cgroup: '%s'", host)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.HasSuffix(line, ":/") {
+			t.Fatalf("the container's cgroup is not its root: '%s'", out.String())
+		}
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -6,7 +6,6 @@ package lnxns
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
@@ -114,12 +113,16 @@ func (cg *Cgroup) ctlPath(controller string, file string) (p string) {
 // add a process by pid, automatically getting all threads
 func (cg *Cgroup) AddProcess(pid int) {
 	for _, name := range ListControllers() {
-		taskFile := path.Join(name, "tasks")
+		taskFile := path.Join(name, cg.Name, "tasks")
 		cg.vfs.SetString(taskFile, strconv.Itoa(pid))
 
-		pid_tasks, _ := ioutil.ReadDir(path.Join("/proc", strconv.Itoa(pid), "task"))
-		for _, fi := range pid_tasks {
-			cg.vfs.SetString(taskFile, fi.Name())
+		proc, err := NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		pid_tasks, _ := proc.Threads()
+		for _, tid := range pid_tasks {
+			cg.vfs.SetString(taskFile, strconv.Itoa(tid))
 		}
 	}
 }
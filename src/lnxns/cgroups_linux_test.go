@@ -9,7 +9,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -26,6 +29,41 @@ func TestCgroup(t *testing.T) {
 	vr.SetString("memory/tasks", "123\n456\n789")
 }
 
+func TestCgroupAddProcess(t *testing.T) {
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-cgroups")
+	defer os.RemoveAll(tmpPath)
+
+	for _, ctl := range lnxns.ListControllers() {
+		os.Mkdir(path.Join(tmpPath, ctl), 0755)
+	}
+	vfs, _ := lnxns.NewVfs(tmpPath)
+	cg, err := lnxns.NewCgroup(vfs, "junk")
+	if err != nil {
+		t.Fatalf("NewCgroup failed: %s", err)
+	}
+
+	child := exec.Command("sleep", "10")
+	if err = child.Start(); err != nil {
+		t.Fatalf("could not start a child process: %s", err)
+	}
+	defer func() {
+		child.Process.Kill()
+		child.Wait()
+	}()
+
+	// the pid goes in the group's tasks, not the root group of each controller
+	cg.AddProcess(child.Process.Pid)
+	for _, ctl := range lnxns.ListControllers() {
+		data, err := ioutil.ReadFile(path.Join(tmpPath, ctl, "junk", "tasks"))
+		if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(child.Process.Pid) {
+			t.Fatalf("AddProcess did not write %s/junk/tasks, Got: '%s', %v", ctl, data, err)
+		}
+		if _, err = os.Stat(path.Join(tmpPath, ctl, "tasks")); err == nil {
+			t.Fatalf("AddProcess wrote the root group's tasks file for %s", ctl)
+		}
+	}
+}
+
 func TestFindCgroups(t *testing.T) {
 	vfs := lnxns.FindCgroupVfs()
 	fmt.Printf("VFS: %s\n", vfs)
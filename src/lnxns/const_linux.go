@@ -6,17 +6,36 @@ package lnxns
 
 // from /usr/include/linux/sched.h
 const (
-	CLONE_FS      = 0x00000200 /* set if fs info shared between processes */
-	CLONE_FILES   = 0x00000400 /* set if open files shared between processes */
-	CLONE_NEWNS   = 0x00020000 /* New namespace group? */
-	CLONE_NEWUTS  = 0x04000000 /* New utsname group? */
-	CLONE_NEWIPC  = 0x08000000 /* New ipcs */
-	CLONE_NEWUSER = 0x10000000 /* New user namespace */
-	CLONE_NEWPID  = 0x20000000 /* New pid namespace */
-	CLONE_NEWNET  = 0x40000000 /* New network namespace */
-	CLONE_IO      = 0x80000000 /* Clone io context */
-	CLONE_VFORK   = 0x00004000 /* set if the parent wants the child to wake it up on mm_release */
-	SIGCHLD       = 0x14       /* Should set SIGCHLD for fork()-like behavior on Linux */
+	CLONE_NEWTIME   = 0x00000080 /* New time namespace, unshare(2) and clone3(2) only */
+	CLONE_FS        = 0x00000200 /* set if fs info shared between processes */
+	CLONE_FILES     = 0x00000400 /* set if open files shared between processes */
+	CLONE_NEWNS     = 0x00020000 /* New namespace group? */
+	CLONE_NEWCGROUP = 0x02000000 /* New cgroup namespace */
+	CLONE_NEWUTS    = 0x04000000 /* New utsname group? */
+	CLONE_NEWIPC    = 0x08000000 /* New ipcs */
+	CLONE_NEWUSER   = 0x10000000 /* New user namespace */
+	CLONE_NEWPID    = 0x20000000 /* New pid namespace */
+	CLONE_NEWNET    = 0x40000000 /* New network namespace */
+	CLONE_IO        = 0x80000000 /* Clone io context */
+	CLONE_VFORK     = 0x00004000 /* set if the parent wants the child to wake it up on mm_release */
+	SIGCHLD         = 0x14       /* Should set SIGCHLD for fork()-like behavior on Linux */
+)
+
+// from /usr/include/linux/prctl.h
+const (
+	PR_SET_CHILD_SUBREAPER = 36 /* orphaned descendants are reparented to us instead of init */
+)
+
+// from /usr/include/linux/fcntl.h
+const (
+	AT_FDCWD            = -100  /* use the current directory for *at() syscalls */
+	AT_SYMLINK_NOFOLLOW = 0x100 /* do not follow symbolic links */
+)
+
+// from /usr/include/linux/magic.h
+const (
+	RAMFS_MAGIC = 0x858458f6
+	TMPFS_MAGIC = 0x01021994
 )
 
 // vim: ts=4 sw=4 noet tw=120 softtabstop=4
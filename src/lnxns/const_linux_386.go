@@ -0,0 +1,13 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+// setns(2) is missing from the syscall package's tables on 386, every other
+// architecture gets it from there in const_setns_linux.go
+const (
+	SYS_SETNS = 346
+)
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,16 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !mips && !mipsle && !mips64 && !mips64le
+
+package lnxns
+
+// pidfd syscalls came after the per-arch tables were unified, they have the same
+// number everywhere except on mips which offsets its ABIs, see const_pidfd_linux_mips*.go
+const (
+	SYS_PIDFD_SEND_SIGNAL = 424
+	SYS_PIDFD_OPEN        = 434
+)
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,15 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build mips64 || mips64le
+
+package lnxns
+
+// the n64 ABI numbers its syscalls from 5000
+const (
+	SYS_PIDFD_SEND_SIGNAL = 5424
+	SYS_PIDFD_OPEN        = 5434
+)
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
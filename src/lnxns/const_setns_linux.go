@@ -0,0 +1,16 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !386 && !amd64
+
+package lnxns
+
+import "syscall"
+
+// 386 and amd64 define their own in const_linux_{386,amd64}.go
+const (
+	SYS_SETNS = syscall.SYS_SETNS
+)
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
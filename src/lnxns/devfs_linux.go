@@ -0,0 +1,131 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+)
+
+// a device node every container gets in its /dev
+type devNode struct {
+	name  string
+	major uint32
+	minor uint32
+}
+
+// the same set docker and runc give containers
+var defaultDevNodes = []devNode{
+	{"null", 1, 3},
+	{"zero", 1, 5},
+	{"full", 1, 7},
+	{"random", 1, 8},
+	{"urandom", 1, 9},
+	{"tty", 5, 0},
+}
+
+// symlinks in /dev that programs expect, e.g. bash's <(...) needs /dev/fd
+var defaultDevSymlinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
+}
+
+// give the container a minimal /dev of its own: a tmpfs with the default device
+// nodes, symlinks, a private devpts instance and /dev/shm. Runs before the rootfs
+// is pivoted to, in a new mount namespace.
+func setupDev(root string) error {
+	dev, err := resolveInRoot(root, "dev")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+
+	// everything below is created on the fresh tmpfs, there are no symlinks to follow
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_STRICTATIME)
+	if err = syscall.Mount("tmpfs", dev, "tmpfs", flags, "mode=755,size=65536k"); err != nil {
+		return fmt.Errorf("could not mount tmpfs on %s: %s", dev, err)
+	}
+
+	// the modes below are meant as given
+	oldUmask := syscall.Umask(0)
+	defer syscall.Umask(oldUmask)
+
+	for _, node := range defaultDevNodes {
+		if err := makeDevNode(dev, node); err != nil {
+			return err
+		}
+	}
+
+	for name, target := range defaultDevSymlinks {
+		if err := os.Symlink(target, path.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range []string{"pts", "shm", "mqueue"} {
+		if err := os.Mkdir(path.Join(dev, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	// a new devpts instance so the container only sees its own ptys. gid=5 is the
+	// usual tty group, which a user namespace might not have mapped.
+	pts := path.Join(dev, "pts")
+	ptsFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC)
+	err = syscall.Mount("devpts", pts, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620,gid=5")
+	if err != nil {
+		err = syscall.Mount("devpts", pts, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620")
+	}
+	if err != nil {
+		return fmt.Errorf("could not mount devpts on %s: %s", pts, err)
+	}
+
+	shm := path.Join(dev, "shm")
+	shmFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err = syscall.Mount("shm", shm, "tmpfs", shmFlags, "mode=1777,size=65536k"); err != nil {
+		return fmt.Errorf("could not mount tmpfs on %s: %s", shm, err)
+	}
+
+	return nil
+}
+
+// mknod a device, or bind-mount the host's over an empty file when we aren't
+// allowed to, which is always the case in a user namespace
+func makeDevNode(dev string, node devNode) error {
+	p := path.Join(dev, node.name)
+	err := syscall.Mknod(p, syscall.S_IFCHR|0666, mkdev(node.major, node.minor))
+	if err == nil {
+		return nil
+	} else if err != syscall.EPERM {
+		return fmt.Errorf("mknod %s: %s", p, err)
+	}
+
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	host := path.Join("/dev", node.name)
+	if err = syscall.Mount(host, p, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("could not bind %s to %s: %s", host, p, err)
+	}
+	return nil
+}
+
+// the kernel's dev_t encoding, 12 bits of major and 20 of minor split around each other
+func mkdev(major, minor uint32) int {
+	ma, mi := uint64(major), uint64(minor)
+	return int(mi&0xff | (ma&0xfff)<<8 | (mi&^0xff)<<12 | (ma&^0xfff)<<32)
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
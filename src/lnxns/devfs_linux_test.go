@@ -0,0 +1,45 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLaunchDev(t *testing.T) {
+	root, cleanup := testRootfs(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	script := `head -c 4 /dev/zero | od -An -tx1; echo hi > /dev/null; readlink /dev/ptmx;` +
+		`stat -f -c %T /dev/pts /dev/shm; test -c /dev/urandom && echo urandom`
+
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:   "sh",
+		Args:   []string{"sh", "-c", script},
+		Root:   root,
+		Dev:    true,
+		Stdout: &out,
+	})
+	res, err := c.Wait()
+	if err != nil || !res.Success() {
+		t.Fatalf("the command failed: %v %v, Output: '%s'", res, err, out.String())
+	}
+
+	expected := "00 00 00 00\npts/ptmx\ndevpts\ntmpfs\nurandom\n"
+	if got := strings.TrimLeft(out.String(), " "); got != expected {
+		t.Fatalf("/dev was not set up, Expected: '%s' Got: '%s'", expected, got)
+	}
+
+	_, err = lnxns.Launch(&lnxns.Config{Path: "true", Dev: true})
+	if err == nil {
+		t.Fatalf("Launch setting up /dev without a Root should fail.")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
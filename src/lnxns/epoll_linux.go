@@ -0,0 +1,94 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"sync"
+	"syscall"
+)
+
+// epoller blocks on a set of file descriptors and can be woken up from
+// another goroutine, which is how the watchers shut down cleanly.
+type epoller struct {
+	epfd  int
+	wakeR int
+	wakeW int
+	mtx   sync.Mutex
+}
+
+func newEpoller() (*epoller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipe [2]int
+	if err = syscall.Pipe2(pipe[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+
+	ep := epoller{epfd: epfd, wakeR: pipe[0], wakeW: pipe[1]}
+	if err = ep.add(ep.wakeR, syscall.EPOLLIN); err != nil {
+		ep.close()
+		return nil, err
+	}
+
+	return &ep, nil
+}
+
+// start watching fd for events, e.g. syscall.EPOLLIN or syscall.EPOLLPRI
+func (ep *epoller) add(fd int, events uint32) error {
+	ev := syscall.EpollEvent{Events: events, Fd: int32(fd)}
+	return syscall.EpollCtl(ep.epfd, syscall.EPOLL_CTL_ADD, fd, &ev)
+}
+
+// block until at least one fd is ready or timeout (in ms, -1 for forever) passes,
+// returns the ready fds and whether wake() was called
+func (ep *epoller) wait(timeout int) (ready []int, woken bool, err error) {
+	events := make([]syscall.EpollEvent, 8)
+
+	n, err := syscall.EpollWait(ep.epfd, events, timeout)
+	if err == syscall.EINTR {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	for _, ev := range events[:n] {
+		if int(ev.Fd) == ep.wakeR {
+			woken = true
+		} else {
+			ready = append(ready, int(ev.Fd))
+		}
+	}
+
+	return
+}
+
+// wake up a goroutine blocked in wait(), safe to call after close()
+func (ep *epoller) wake() {
+	ep.mtx.Lock()
+	defer ep.mtx.Unlock()
+
+	if ep.wakeW >= 0 {
+		syscall.Write(ep.wakeW, []byte{0})
+	}
+}
+
+func (ep *epoller) close() {
+	ep.mtx.Lock()
+	defer ep.mtx.Unlock()
+
+	if ep.epfd < 0 {
+		return
+	}
+	syscall.Close(ep.wakeR)
+	syscall.Close(ep.wakeW)
+	syscall.Close(ep.epfd)
+	ep.epfd, ep.wakeR, ep.wakeW = -1, -1, -1
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,507 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Descriptor points at a blob in an OCI image layout, see the OCI image spec.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// the parts of an image's config that matter for running it, docker uses the same format
+type ImageConfig struct {
+	User       string   // user name or uid, optionally with :group or :gid
+	Env        []string // KEY=value
+	Entrypoint []string
+	Cmd        []string
+	WorkingDir string
+}
+
+// Image is an image in a local OCI image layout or a docker save directory.
+// Nothing is fetched from a registry.
+type Image struct {
+	Digest string // of the image's config, which identifies the image
+	Config ImageConfig
+	layers []imageBlob // bottom first
+}
+
+// a file in the image, its digest and for layers the digest of the uncompressed tar
+// from the config's rootfs.diff_ids. The digest is empty when there's none to check,
+// classic docker save layers only have their diff id.
+type imageBlob struct {
+	path   string
+	digest string
+	diffID string
+}
+
+// annotations that name a manifest in index.json, as set by skopeo and docker
+var imageRefAnnotations = []string{"org.opencontainers.image.ref.name", "io.containerd.image.name"}
+
+const (
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerIndex = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// open the image in dir, the output of e.g. `skopeo copy ... oci:dir:ref` or an
+// unpacked `docker save`. ref picks an image by its tag or name when there's more
+// than one, it can be empty otherwise.
+func OpenImage(dir, ref string) (*Image, error) {
+	if _, err := os.Stat(path.Join(dir, "oci-layout")); err == nil {
+		return openOCIImage(dir, ref)
+	} else if _, err := os.Stat(path.Join(dir, "manifest.json")); err == nil {
+		return openDockerImage(dir, ref)
+	}
+	return nil, fmt.Errorf("%s is neither an OCI image layout nor a docker save directory", dir)
+}
+
+func openOCIImage(dir, ref string) (*Image, error) {
+	var index struct {
+		Manifests []Descriptor `json:"manifests"`
+	}
+	if err := readJSON(path.Join(dir, "index.json"), &index); err != nil {
+		return nil, err
+	}
+
+	var matches []Descriptor
+	for _, desc := range index.Manifests {
+		for _, key := range imageRefAnnotations {
+			if ref == "" || desc.Annotations[key] == ref {
+				matches = append(matches, desc)
+				break
+			}
+		}
+	}
+	if len(matches) != 1 {
+		return nil, fmt.Errorf("%d images in %s match '%s', expected one", len(matches), dir, ref)
+	}
+
+	desc := matches[0]
+
+	// multi-platform images have an index of their own
+	for desc.MediaType == mediaTypeOCIIndex || desc.MediaType == mediaTypeDockerIndex {
+		var nested struct {
+			Manifests []Descriptor `json:"manifests"`
+		}
+		if err := readBlob(ociBlob(dir, desc), &nested); err != nil {
+			return nil, err
+		}
+		var err error
+		if desc, err = pickPlatform(nested.Manifests); err != nil {
+			return nil, err
+		}
+	}
+
+	var manifest struct {
+		Config Descriptor   `json:"config"`
+		Layers []Descriptor `json:"layers"`
+	}
+	if err := readBlob(ociBlob(dir, desc), &manifest); err != nil {
+		return nil, err
+	}
+
+	var img Image
+	for _, layer := range manifest.Layers {
+		if strings.HasSuffix(layer.MediaType, "zstd") {
+			return nil, fmt.Errorf("layer %s: zstd compressed layers are not supported", layer.Digest)
+		}
+		img.layers = append(img.layers, ociBlob(dir, layer))
+	}
+
+	if err := img.readConfig(ociBlob(dir, manifest.Config)); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// blobs are stored by their digest, blobs/sha256/<hex>. A bad digest gives a
+// path that can't exist rather than one outside of dir.
+func ociBlob(dir string, desc Descriptor) imageBlob {
+	alg, hexDigest, err := splitDigest(desc.Digest)
+	if err != nil {
+		return imageBlob{path: path.Join(dir, "blobs", "invalid"), digest: desc.Digest}
+	}
+	return imageBlob{path: path.Join(dir, "blobs", alg, hexDigest), digest: desc.Digest}
+}
+
+// the manifest for this machine from a multi-platform index
+func pickPlatform(manifests []Descriptor) (Descriptor, error) {
+	for _, desc := range manifests {
+		if desc.Platform != nil && desc.Platform.OS == "linux" && desc.Platform.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no image for linux/%s", runtime.GOARCH)
+}
+
+func openDockerImage(dir, ref string) (*Image, error) {
+	var manifests []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	if err := readJSON(path.Join(dir, "manifest.json"), &manifests); err != nil {
+		return nil, err
+	}
+
+	var found []int
+	for i, m := range manifests {
+		for _, tag := range m.RepoTags {
+			if ref == "" || tag == ref {
+				found = append(found, i)
+				break
+			}
+		}
+		if ref == "" && len(m.RepoTags) == 0 {
+			found = append(found, i)
+		}
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("%d images in %s match '%s', expected one", len(found), dir, ref)
+	}
+	m := manifests[found[0]]
+
+	// older versions of docker name layers <id>/layer.tar, where the id isn't a
+	// digest of the layer, newer ones use OCI style blobs/sha256/<hex>
+	var img Image
+	for _, layer := range m.Layers {
+		if _, err := layerPath(layer); err != nil {
+			return nil, err
+		}
+		blob := imageBlob{path: path.Join(dir, layer)}
+		if strings.HasPrefix(layer, "blobs/sha256/") {
+			blob.digest = "sha256:" + path.Base(layer)
+		}
+		img.layers = append(img.layers, blob)
+	}
+
+	// the config is named after its digest either way, <hex>.json or blobs/sha256/<hex>
+	if _, err := layerPath(m.Config); err != nil {
+		return nil, err
+	}
+	digest := "sha256:" + strings.TrimSuffix(path.Base(m.Config), ".json")
+	if err := img.readConfig(imageBlob{path: path.Join(dir, m.Config), digest: digest}); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// read the image config and keep its digest as the image's id. The config lists the
+// digests of the uncompressed layers, so with them checked the id covers every layer.
+func (img *Image) readConfig(blob imageBlob) error {
+	var config struct {
+		Config ImageConfig `json:"config"`
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
+	if err := readBlob(blob, &config); err != nil {
+		return err
+	}
+
+	if len(config.RootFS.DiffIDs) != len(img.layers) {
+		return fmt.Errorf("the image config lists %d layers, the manifest %d", len(config.RootFS.DiffIDs), len(img.layers))
+	}
+	for i, diffID := range config.RootFS.DiffIDs {
+		if _, _, err := splitDigest(diffID); err != nil {
+			return err
+		}
+		img.layers[i].diffID = diffID
+	}
+
+	img.Digest = blob.digest
+	img.Config = config.Config
+	return nil
+}
+
+// decode a JSON blob after checking its digest
+func readBlob(blob imageBlob, v interface{}) error {
+	f, h, err := blob.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if err = checkDigest(blob.path, blob.digest, h); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// open a blob along with a hash of everything read from it, nil when there's no
+// digest to check it against
+func (blob imageBlob) open() (io.ReadCloser, hash.Hash, error) {
+	h, err := newDigestHash(blob.digest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(blob.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h == nil {
+		return f, nil, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(f, h), f}, h, nil
+}
+
+// a hash for the algorithm of digest, nil for an empty digest
+func newDigestHash(digest string) (hash.Hash, error) {
+	if digest == "" {
+		return nil, nil
+	}
+
+	alg, _, err := splitDigest(digest)
+	if err != nil {
+		return nil, err
+	} else if alg == "sha512" {
+		return sha512.New(), nil
+	}
+	return sha256.New(), nil
+}
+
+// compare what h has seen with digest, a nil h has nothing to check
+func checkDigest(name, digest string, h hash.Hash) error {
+	if h == nil {
+		return nil
+	}
+	alg, _, _ := splitDigest(digest)
+	if got := alg + ":" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("%s is corrupt, its digest is %s instead of %s", name, got, digest)
+	}
+	return nil
+}
+
+// unpack the image's layers in order into cacheDir/<hex of the config digest> and
+// return that path, ready to be used as a Config.Root. Like UnpackRootfs an image that
+// was unpacked before is reused, so the tree must be treated as read-only.
+func (img *Image) Unpack(cacheDir string) (string, error) {
+	_, hexDigest, err := splitDigest(img.Digest)
+	if err != nil {
+		return "", err
+	}
+
+	rootfs := path.Join(cacheDir, hexDigest)
+	if _, err = os.Stat(rootfs); err == nil {
+		return rootfs, nil
+	}
+
+	err = unpackCached(rootfs, func(dir string) error {
+		for _, layer := range img.layers {
+			if err := img.applyLayer(dir, layer); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return rootfs, nil
+}
+
+// apply one layer, checking the blob against its digest and the tar inside against
+// its diff id
+func (img *Image) applyLayer(dir string, layer imageBlob) error {
+	f, h, err := layer.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	diffHash, err := newDigestHash(layer.diffID)
+	if err != nil {
+		return err
+	}
+	r, err := gunzipLayer(f)
+	if err != nil {
+		return fmt.Errorf("layer %s: %s", layer.path, err)
+	}
+	tr := io.TeeReader(r, diffHash)
+
+	if err = ApplyLayer(dir, tr); err != nil {
+		return fmt.Errorf("layer %s: %s", layer.path, err)
+	}
+
+	// the end of the tar stream isn't the end of the blob, the rest counts too
+	if _, err = io.Copy(ioutil.Discard, tr); err != nil {
+		return err
+	}
+	if _, err = io.Copy(ioutil.Discard, f); err != nil {
+		return err
+	}
+	if err = checkDigest(layer.path, layer.diffID, diffHash); err != nil {
+		return err
+	}
+	return checkDigest(layer.path, layer.digest, h)
+}
+
+// split e.g. sha256:abc..., the parts end up in paths so they're checked strictly
+func splitDigest(digest string) (alg, hexDigest string, err error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || (parts[0] != "sha256" && parts[0] != "sha512") {
+		return "", "", fmt.Errorf("invalid digest '%s'", digest)
+	}
+	if _, err = hex.DecodeString(parts[1]); err != nil || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid digest '%s'", digest)
+	}
+	return parts[0], parts[1], nil
+}
+
+func readJSON(p string, v interface{}) error {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %s", p, err)
+	}
+	return nil
+}
+
+// use rootfs as the container's Root and fill in what the config leaves empty from
+// the image: the command from Entrypoint and Cmd, the working directory and the user,
+// which is looked up in the rootfs' /etc/passwd and /etc/group. The image's Env comes
+// first in the environment and cfg.Env is added on top.
+// e.g.
+// img, _ := lnxns.OpenImage("/srv/images/alpine", "latest")
+// rootfs, _ := img.Unpack(lnxns.RootfsCacheDir)
+// cfg.SetImage(rootfs, &img.Config)
+func (cfg *Config) SetImage(rootfs string, image *ImageConfig) error {
+	cfg.Root = rootfs
+
+	if cfg.Path == "" {
+		argv := append(append([]string{}, image.Entrypoint...), image.Cmd...)
+		if len(argv) == 0 {
+			return errors.New("the image has no Entrypoint or Cmd, a command is needed")
+		}
+		cfg.Path = argv[0]
+		cfg.Args = argv
+	}
+
+	env := append([]string{}, image.Env...)
+	for _, kv := range cfg.Env {
+		key := strings.SplitN(kv, "=", 2)[0] + "="
+		for i := range env {
+			if strings.HasPrefix(env[i], key) {
+				env = append(env[:i], env[i+1:]...)
+				break
+			}
+		}
+		env = append(env, kv)
+	}
+	cfg.Env = env
+
+	if cfg.Dir == "" {
+		cfg.Dir = image.WorkingDir
+	}
+
+	if cfg.Uid == 0 && cfg.Gid == 0 && image.User != "" {
+		uid, gid, err := lookupUser(rootfs, image.User)
+		if err != nil {
+			return err
+		}
+		cfg.Uid, cfg.Gid = uid, gid
+	}
+
+	return nil
+}
+
+// resolve user[:group] the way the image's own tools would, names come from
+// rootfs/etc/passwd and rootfs/etc/group
+func lookupUser(rootfs, spec string) (uid, gid int, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+
+	var found bool
+	uid, gid, found, err = lookupIDs(rootfs, "/etc/passwd", parts[0])
+	if err != nil {
+		return
+	} else if !found {
+		return 0, 0, fmt.Errorf("no user '%s' in the image", parts[0])
+	}
+
+	if len(parts) == 2 {
+		if gid, _, found, err = lookupIDs(rootfs, "/etc/group", parts[1]); err != nil {
+			return
+		} else if !found {
+			return 0, 0, fmt.Errorf("no group '%s' in the image", parts[1])
+		}
+	}
+
+	return
+}
+
+// find a name or a numeric id in a passwd or group format file and return its id
+// and, for passwd, its primary group. A number that isn't in the file is used as is.
+func lookupIDs(rootfs, file, name string) (id, gid int, found bool, err error) {
+	number, nerr := strconv.Atoi(name)
+
+	p, err := resolveInRoot(rootfs, file)
+	if err != nil {
+		return
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return number, 0, nerr == nil, nil
+	} else if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 || (fields[0] != name && (nerr != nil || fields[2] != name)) {
+			continue
+		}
+
+		if id, err = strconv.Atoi(fields[2]); err != nil {
+			return
+		}
+		if len(fields) > 3 {
+			gid, _ = strconv.Atoi(fields[3])
+		}
+		return id, gid, true, nil
+	}
+
+	return number, 0, nerr == nil, scanner.Err()
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,257 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+)
+
+var testImageConfig = lnxns.ImageConfig{
+	User:       "app",
+	Env:        []string{"PATH=/usr/bin:/bin", "FOO=image"},
+	Cmd:        []string{"sh", "-c", "id -u; id -g; pwd; echo $FOO"},
+	WorkingDir: "/app",
+}
+
+// two layers, the second one deletes a file from the first
+func testImageLayers(t *testing.T) [][]byte {
+	return [][]byte{
+		testTarball(t, true,
+			tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0644,
+				Linkname: "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1001::/app:/bin/sh\n"},
+			tar.Header{Name: "etc/group", Typeflag: tar.TypeReg, Mode: 0644, Linkname: "root:x:0:\napp:x:1001:\nstaff:x:50:\n"},
+			tar.Header{Name: "app/", Typeflag: tar.TypeDir, Uid: 1000, Gid: 1001},
+			tar.Header{Name: "app/old", Typeflag: tar.TypeReg, Linkname: "old"},
+		),
+		testTarball(t, false,
+			tar.Header{Name: "app/.wh.old", Typeflag: tar.TypeReg},
+			tar.Header{Name: "app/new", Typeflag: tar.TypeReg, Linkname: "new"},
+		),
+	}
+}
+
+// the digests of the uncompressed layers, as an image config lists them in rootfs.diff_ids
+func testDiffIDs(layers [][]byte) []string {
+	var ids []string
+	for _, data := range layers {
+		var r io.Reader = bytes.NewReader(data)
+		if zr, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+			r = zr
+		}
+		h := sha256.New()
+		io.Copy(h, r)
+		ids = append(ids, "sha256:"+hex.EncodeToString(h.Sum(nil)))
+	}
+	return ids
+}
+
+// store data as a blob in an OCI layout and describe it
+func writeBlob(t *testing.T, dir, mediaType string, data []byte) lnxns.Descriptor {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	os.MkdirAll(path.Join(dir, "blobs", "sha256"), 0755)
+	if err := ioutil.WriteFile(path.Join(dir, "blobs", "sha256", digest), data, 0644); err != nil {
+		t.Fatalf("Could not write a blob: %s", err)
+	}
+	return lnxns.Descriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(data))}
+}
+
+func mustJSON(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+// an OCI image layout like skopeo copy writes, with a multi-platform index
+func testOCILayout(t *testing.T, dir string) (layers []lnxns.Descriptor) {
+	data := testImageLayers(t)
+	for _, layer := range data {
+		layers = append(layers, writeBlob(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", layer))
+	}
+
+	config := writeBlob(t, dir, "application/vnd.oci.image.config.v1+json", mustJSON(map[string]interface{}{
+		"architecture": runtime.GOARCH,
+		"os":           "linux",
+		"config":       testImageConfig,
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": testDiffIDs(data)},
+	}))
+	manifest := writeBlob(t, dir, "application/vnd.oci.image.manifest.v1+json", mustJSON(map[string]interface{}{
+		"schemaVersion": 2,
+		"config":        config,
+		"layers":        layers,
+	}))
+	manifest.Platform = &lnxns.Platform{Architecture: runtime.GOARCH, OS: "linux"}
+	other := lnxns.Descriptor{MediaType: manifest.MediaType, Digest: "sha256:00", Platform: &lnxns.Platform{"s390x", "linux"}}
+
+	nested := writeBlob(t, dir, "application/vnd.oci.image.index.v1+json", mustJSON(map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests":     []lnxns.Descriptor{other, manifest},
+	}))
+	nested.Annotations = map[string]string{"org.opencontainers.image.ref.name": "latest"}
+
+	ioutil.WriteFile(path.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644)
+	ioutil.WriteFile(path.Join(dir, "index.json"), mustJSON(map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests":     []lnxns.Descriptor{nested},
+	}), 0644)
+
+	return layers
+}
+
+func TestOCIImage(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	layers := testOCILayout(t, path.Join(dir, "image"))
+
+	if _, err := lnxns.OpenImage(path.Join(dir, "image"), "nope"); err == nil {
+		t.Fatalf("OpenImage with a ref that isn't in the image should fail")
+	}
+
+	img, err := lnxns.OpenImage(path.Join(dir, "image"), "latest")
+	if err != nil {
+		t.Fatalf("OpenImage failed: %s", err)
+	}
+	if img.Config.User != "app" || img.Config.WorkingDir != "/app" || len(img.Config.Cmd) != 3 {
+		t.Fatalf("the image config was not read, Got: %+v", img.Config)
+	}
+
+	rootfs, err := img.Unpack(path.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Unpack failed: %s", err)
+	}
+	if path.Base(rootfs) != img.Digest[len("sha256:"):] {
+		t.Fatalf("the rootfs should be named after the image's digest %s, Got: %s", img.Digest, rootfs)
+	}
+	if _, err = os.Stat(path.Join(rootfs, "app/old")); !os.IsNotExist(err) {
+		t.Fatalf("app/old should have been deleted by the second layer: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(path.Join(rootfs, "app/new")); string(data) != "new" {
+		t.Fatalf("the second layer was not applied, Got: '%s'", data)
+	}
+
+	// a layer that doesn't match its digest is refused
+	ioutil.WriteFile(path.Join(dir, "image", "blobs", "sha256", layers[1].Digest[len("sha256:"):]),
+		testTarball(t, false, tar.Header{Name: "evil", Typeflag: tar.TypeReg}), 0644)
+	if _, err = img.Unpack(path.Join(dir, "cache2")); err == nil {
+		t.Fatalf("Unpack of a corrupt layer should fail")
+	}
+}
+
+func TestDockerSaveImage(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// the classic docker save layout, <id>/layer.tar and <config digest>.json
+	var paths []string
+	layers := testImageLayers(t)
+	for i, data := range layers {
+		p := path.Join(string(rune('a'+i))+"0", "layer.tar")
+		os.MkdirAll(path.Join(dir, path.Dir(p)), 0755)
+		ioutil.WriteFile(path.Join(dir, p), data, 0644)
+		paths = append(paths, p)
+	}
+	config := mustJSON(map[string]interface{}{
+		"config": testImageConfig,
+		"rootfs": map[string]interface{}{"type": "layers", "diff_ids": testDiffIDs(layers)},
+	})
+	sum := sha256.Sum256(config)
+	configName := hex.EncodeToString(sum[:]) + ".json"
+	ioutil.WriteFile(path.Join(dir, configName), config, 0644)
+	ioutil.WriteFile(path.Join(dir, "manifest.json"), mustJSON([]map[string]interface{}{
+		{"Config": configName, "RepoTags": []string{"app:latest"}, "Layers": paths},
+	}), 0644)
+
+	img, err := lnxns.OpenImage(dir, "app:latest")
+	if err != nil {
+		t.Fatalf("OpenImage failed: %s", err)
+	}
+	rootfs, err := img.Unpack(path.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Unpack failed: %s", err)
+	}
+	if data, _ := ioutil.ReadFile(path.Join(rootfs, "app/new")); string(data) != "new" {
+		t.Fatalf("the layers were not applied, Got: '%s'", data)
+	}
+
+	// users are looked up in the image, not on the host
+	for spec, expected := range map[string][2]int{"app": {1000, 1001}, "app:staff": {1000, 50}, "1234": {1234, 0}, "0:app": {0, 1001}} {
+		cfg := lnxns.Config{Path: "true", Env: []string{"FOO=mine"}}
+		image := img.Config
+		image.User = spec
+		if err = cfg.SetImage(rootfs, &image); err != nil {
+			t.Fatalf("SetImage with user '%s' failed: %s", spec, err)
+		}
+		if cfg.Uid != expected[0] || cfg.Gid != expected[1] {
+			t.Fatalf("user '%s' should be %v, Got: %d:%d", spec, expected, cfg.Uid, cfg.Gid)
+		}
+		if cfg.Root != rootfs || cfg.Dir != "/app" || cfg.Path != "true" || cfg.Env[1] != "FOO=mine" {
+			t.Fatalf("SetImage did not set the defaults, Got: %+v", cfg)
+		}
+	}
+
+	cfg := lnxns.Config{}
+	image := lnxns.ImageConfig{User: "nobody"}
+	if err = cfg.SetImage(rootfs, &image); err == nil {
+		t.Fatalf("SetImage with a user that isn't in the image or a command should fail")
+	}
+
+	// the layer.tar files have no digest of their own, the config's diff_ids cover them
+	ioutil.WriteFile(path.Join(dir, paths[1]), testTarball(t, false, tar.Header{Name: "evil", Typeflag: tar.TypeReg}), 0644)
+	if _, err = img.Unpack(path.Join(dir, "cache2")); err == nil {
+		t.Fatalf("Unpack of a layer that doesn't match its diff id should fail")
+	}
+}
+
+func TestLaunchImage(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("launching containers requires root")
+	}
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	testOCILayout(t, path.Join(dir, "image"))
+
+	img, err := lnxns.OpenImage(path.Join(dir, "image"), "")
+	if err != nil {
+		t.Fatalf("OpenImage failed: %s", err)
+	}
+	rootfs, err := img.Unpack(path.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Unpack failed: %s", err)
+	}
+
+	var out bytes.Buffer
+	cfg := lnxns.Config{
+		Volumes: testBaseLayer(t, rootfs),
+		Stdout:  &out,
+		Stderr:  &out,
+	}
+	if err = cfg.SetImage(rootfs, &img.Config); err != nil {
+		t.Fatalf("SetImage failed: %s", err)
+	}
+
+	c := launchOrSkip(t, &cfg)
+	if res, err := c.Wait(); err != nil || !res.Success() {
+		t.Fatalf("the image's command failed: %v %v, Output: '%s'", res, err, out.String())
+	}
+
+	if out.String() != "1000\n1001\n/app\nimage\n" {
+		t.Fatalf("the image's defaults were not used, Got: '%s'", out.String())
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,261 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// argv[0] of the copy of the program that sets up a container for Launch
+const initArgv0 = "lnxns-init"
+
+// argv[1] that tells the init stage it is in a new user namespace
+const initUsernsArg = "userns"
+
+// file descriptors passed to the init stage after stdin/stdout/stderr
+const (
+	initConfigFd = 3
+	initErrorFd  = 4
+	initSyncFd   = 5 // only with a new user namespace
+)
+
+// Init runs the inside half of Launch and never returns when the program was
+// started by Launch. Call it first thing in main(), it does nothing otherwise.
+// e.g. func main() { lnxns.Init(); ... }
+func Init() {
+	if len(os.Args) == 0 || os.Args[0] != initArgv0 {
+		return
+	}
+
+	// all of the setup has to happen on one thread, the namespaces of the
+	// thread that calls execve are the ones the command ends up in
+	runtime.LockOSThread()
+
+	errPipe := os.NewFile(initErrorFd, "error pipe")
+
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == initUsernsArg {
+		err = reexecAfterIDMaps()
+	} else {
+		syscall.CloseOnExec(initErrorFd)
+		err = initContainer(errPipe)
+	}
+
+	// only reached when something went wrong
+	fmt.Fprintf(errPipe, "%s", err)
+	os.Exit(1)
+}
+
+// A process in a new user namespace only gets its capabilities from an execve
+// done after its id maps are written. So the first init stage waits for the
+// parent to write them and then runs itself again, the config and error pipes
+// are passed along.
+func reexecAfterIDMaps() error {
+	syncPipe := os.NewFile(initSyncFd, "sync pipe")
+	// EOF once the parent is done, if it fails it kills us instead
+	ioutil.ReadAll(syncPipe)
+	syncPipe.Close()
+
+	err := syscall.Exec("/proc/self/exe", []string{initArgv0}, os.Environ())
+	return fmt.Errorf("re-exec in the user namespace: %s", err)
+}
+
+// read the config from the parent, set up the container and exec the command,
+// or with Config.Init start it and stay around as its init
+func initContainer(errPipe *os.File) error {
+	var cfg Config
+
+	configPipe := os.NewFile(initConfigFd, "config pipe")
+	if err := json.NewDecoder(configPipe).Decode(&cfg); err != nil {
+		return fmt.Errorf("could not read config: %s", err)
+	}
+	configPipe.Close()
+
+	// the container's filesystem as seen before the chroot
+	root := cfg.Root
+	if root == "" {
+		root = "/"
+	}
+
+	// keep the mounts below from propagating back to the host, then mount the
+	// overlay if there is one and make the rootfs a mount point so it can be pivoted to
+	newMountNs := cfg.Namespaces.Mount.Mode == NamespaceNew
+	if newMountNs {
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("could not make / a private mount: %s", err)
+		}
+		if cfg.Overlay != nil {
+			userns := cfg.Namespaces.User.Mode == NamespaceNew
+			if err := cfg.Overlay.mount(cfg.Root, userns); err != nil {
+				return err
+			}
+		}
+		if cfg.Root != "" {
+			if err := bindRootfs(cfg.Root); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cfg.Dev {
+		if err := setupDev(root); err != nil {
+			return err
+		}
+	}
+
+	// the container's own proc and sysfs, cgroup filesystems get mounted on top
+	mountsInRoot := cfg.Root != "" && newMountNs
+	if mountsInRoot {
+		if err := mountProcfs(root); err != nil {
+			return err
+		}
+		if err := mountSysfs(root); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Namespaces.Cgroup.Mode == NamespaceNew {
+		if err := unshareCgroupNs(); err != nil {
+			return err
+		}
+
+		if newMountNs {
+			if err := mountCgroupFs(root); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cfg.Namespaces.Time.Mode == NamespaceNew {
+		if err := unshareTimeNs(cfg.TimeOffsets); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Hostname != "" {
+		if err := syscall.Sethostname([]byte(cfg.Hostname)); err != nil {
+			return fmt.Errorf("sethostname %s: %s", cfg.Hostname, err)
+		}
+	}
+	if cfg.Domainname != "" {
+		if err := syscall.Setdomainname([]byte(cfg.Domainname)); err != nil {
+			return fmt.Errorf("setdomainname %s: %s", cfg.Domainname, err)
+		}
+	}
+
+	// /proc/sys shows the sysctls of the namespaces we are in now
+	sc := NewSysctl(ProcFs())
+	for key, value := range cfg.Sysctl {
+		if err := sc.Set(key, value); err != nil {
+			return fmt.Errorf("sysctl %s=%s: %s", key, value, err)
+		}
+	}
+
+	// a POSIX message queue filesystem belongs to the IPC namespace that mounts it
+	if cfg.Namespaces.Ipc.Mode == NamespaceNew && newMountNs {
+		if err := mountMqueue(root); err != nil {
+			return err
+		}
+	}
+
+	if mountsInRoot {
+		for i := range cfg.Volumes {
+			if err := mountVolume(root, &cfg.Volumes[i]); err != nil {
+				return err
+			}
+		}
+
+		if err := maskPaths(root, cfg.MaskedPaths); err != nil {
+			return err
+		}
+		if err := readonlyPaths(root, cfg.ReadonlyPaths); err != nil {
+			return err
+		}
+
+		if err := pivotRootfs(cfg.Root); err != nil {
+			return err
+		}
+	} else if cfg.Root != "" {
+		if err := chrootRootfs(cfg.Root); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Uid != 0 || cfg.Gid != 0 {
+		if err := switchUser(cfg.Uid, cfg.Gid, !cfg.DenySetgroups); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Dir != "" {
+		if err := syscall.Chdir(cfg.Dir); err != nil {
+			return fmt.Errorf("chdir %s: %s", cfg.Dir, err)
+		}
+	}
+
+	program, err := lookPath(cfg.Path, cfg.Env)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Init {
+		return runInit(program, &cfg, errPipe)
+	}
+
+	err = syscall.Exec(program, cfg.Args, cfg.Env)
+	return fmt.Errorf("exec %s: %s", program, err)
+}
+
+// become uid and gid for good, dropping the supplementary groups when allowed to.
+// Go's Setuid and Setgid apply to all threads of the process.
+func switchUser(uid, gid int, setgroups bool) error {
+	if setgroups {
+		if err := syscall.Setgroups(nil); err != nil {
+			return fmt.Errorf("setgroups: %s", err)
+		}
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d: %s", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d: %s", uid, err)
+	}
+	return nil
+}
+
+// like exec.LookPath, but uses the PATH from the container's environment
+func lookPath(file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+
+	search := "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			search = kv[5:]
+		}
+	}
+
+	for _, dir := range strings.Split(search, ":") {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := path.Join(dir, file)
+		if st, err := os.Stat(candidate); err == nil && st.Mode().IsRegular() && st.Mode()&0111 != 0 {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s: not found in PATH %s", file, search)
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,33 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mount a fresh mqueue filesystem on root/dev/mqueue so the container sees the
+// message queues of its own IPC namespace instead of the host's, see mq_overview(7).
+// Nothing is mounted when the container has no /dev/mqueue directory.
+func mountMqueue(root string) error {
+	target, err := resolveInRoot(root, "dev/mqueue")
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(target); err != nil || !st.IsDir() {
+		return nil
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err = syscall.Mount("mqueue", target, "mqueue", flags, ""); err != nil {
+		return fmt.Errorf("could not mount mqueue on %s: %s", target, err)
+	}
+
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,57 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestIpcNamespace(t *testing.T) {
+	root, cleanup := testRootfs(t, "dev/mqueue")
+	defer cleanup()
+
+	var out bytes.Buffer
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:   "stat",
+		Args:   []string{"stat", "-f", "-c", "%T", "/dev/mqueue"},
+		Root:   root,
+		Stdout: &out,
+	})
+	c.Wait()
+
+	if out.String() != "mqueue\n" {
+		t.Fatalf("mqueue was not mounted in the container, Got: '%s'", out.String())
+	}
+
+	out.Reset()
+	c = launchOrSkip(t, &lnxns.Config{
+		Path:   "cat",
+		Args:   []string{"cat", "/proc/sys/kernel/shmmax"},
+		Sysctl: map[string]string{"kernel.shmmax": "123456789"},
+		Stdout: &out,
+	})
+	c.Wait()
+
+	if out.String() != "123456789\n" {
+		t.Fatalf("the container's sysctl was not set, Got: '%s'", out.String())
+	}
+
+	host, _ := lnxns.NewSysctl(lnxns.ProcFs()).Get("kernel.shmmax")
+	if host == "123456789" {
+		t.Fatalf("the container's sysctl leaked to the host")
+	}
+
+	for _, sysctl := range []map[string]string{{"vm.swappiness": "0"}, {"net.ipv4.ip_forward": "1"}} {
+		_, err := lnxns.Launch(&lnxns.Config{Path: "true", Sysctl: sysctl})
+		if err == nil || !strings.Contains(err.Error(), "namespace") {
+			t.Fatalf("Launch should refuse to set %v, Got: '%v'", sysctl, err)
+		}
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
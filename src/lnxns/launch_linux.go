@@ -0,0 +1,356 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Config describes a command to run in new namespaces with Launch.
+type Config struct {
+	Path       string         // program to run, looked up in the container's PATH if it has no slash
+	Args       []string       // argv including argv[0], defaults to [Path]
+	Env        []string       // environment of the command, nil means the caller's environment
+	Dir        string         // working directory inside the container
+	Root       string         // root filesystem, pivoted to with a new mount namespace or chrooted to otherwise
+	Dev        bool           // give Root a fresh /dev with basic devices and ptys, needs a new mount namespace
+	Namespaces *NamespaceSpec // nil means DefaultNamespaceSpec()
+	Hostname   string         // set in the container's new UTS namespace
+	Domainname string         // NIS domain name, also needs a new UTS namespace
+	Init       bool           // run the command under a tiny init that reaps zombies and forwards signals
+	Uid        int            // user to run the command as, in the container's user namespace
+	Gid        int            // group to run the command as, supplementary groups are dropped
+
+	// namespaced sysctls to set in the container, e.g. {"kernel.shmmax": "4294967296"}.
+	// Each key's namespace (net, ipc or uts) must be a new one.
+	Sysctl map[string]string
+
+	// id maps for a new user namespace, when both are empty container root is
+	// mapped to the caller's uid and gid
+	UidMappings   []IDMap
+	GidMappings   []IDMap
+	DenySetgroups bool // disable setgroups(2), required for unprivileged gid maps
+
+	// layers to mount on Root with overlayfs, needs a new mount namespace
+	Overlay *Overlay
+
+	// host paths to bind into Root, needs a new mount namespace
+	Volumes []Volume
+
+	// with a Root and a new mount namespace the container gets its own /proc and a
+	// read-only /sys. Paths in them to hide and to make read-only, nil means
+	// DefaultMaskedPaths and DefaultReadonlyPaths, an empty list none.
+	MaskedPaths   []string
+	ReadonlyPaths []string
+
+	// clock offsets for a new time namespace
+	TimeOffsets TimeOffsets
+
+	// cgroup to put the command in before it starts. With a new cgroup namespace
+	// this group is the container's cgroup root.
+	Cgroup *Cgroup `json:"-"`
+
+	Stdin  io.Reader `json:"-"`
+	Stdout io.Writer `json:"-"`
+	Stderr io.Writer `json:"-"`
+}
+
+// Container is a command started by Launch.
+type Container struct {
+	Pid    int
+	Config *Config
+	cmd    *exec.Cmd
+	pidfd  *Pidfd // nil on kernels without pidfd_open(2)
+	mntNs  uint64 // inode of the container's mount namespace, for Overlay.Discard
+
+	// the command is reaped once, every Wait gets the same result
+	reapOnce sync.Once
+	reaped   chan struct{}
+	result   *Result
+	waitErr  error
+}
+
+// start a command in new namespaces. The namespaces are created by os/exec and the
+// setup inside them happens in a fresh copy of the calling program, so programs
+// using Launch must call Init() at the very top of main().
+// Launch returns once the command has been exec'd. If anything in the container
+// setup fails, the error from inside the container is returned here.
+func Launch(cfg *Config) (*Container, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("no program to launch")
+	}
+
+	// don't modify the caller's config
+	child := *cfg
+	if len(child.Args) == 0 {
+		child.Args = []string{child.Path}
+	}
+	if child.Env == nil {
+		child.Env = os.Environ()
+	}
+	if child.Namespaces == nil {
+		child.Namespaces = DefaultNamespaceSpec()
+	}
+	if err := child.Namespaces.validate(); err != nil {
+		return nil, err
+	}
+	if !child.TimeOffsets.IsZero() && child.Namespaces.Time.Mode != NamespaceNew {
+		return nil, errors.New("time offsets need a new time namespace")
+	}
+	if (child.Hostname != "" || child.Domainname != "") && child.Namespaces.Uts.Mode != NamespaceNew {
+		return nil, errors.New("a hostname or domainname needs a new UTS namespace")
+	}
+	if err := validateSysctls(child.Namespaces, child.Sysctl); err != nil {
+		return nil, err
+	}
+	if child.Overlay != nil {
+		if child.Root == "" || child.Namespaces.Mount.Mode != NamespaceNew {
+			return nil, errors.New("an overlay needs a Root and a new mount namespace")
+		}
+		if err := child.Overlay.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if len(child.Volumes) > 0 && (child.Root == "" || child.Namespaces.Mount.Mode != NamespaceNew) {
+		return nil, errors.New("volumes need a Root and a new mount namespace")
+	}
+	for i := range child.Volumes {
+		if err := child.Volumes[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	if child.MaskedPaths == nil {
+		child.MaskedPaths = DefaultMaskedPaths
+	}
+	if child.ReadonlyPaths == nil {
+		child.ReadonlyPaths = DefaultReadonlyPaths
+	}
+	for _, p := range append(append([]string{}, child.MaskedPaths...), child.ReadonlyPaths...) {
+		if !path.IsAbs(p) {
+			return nil, fmt.Errorf("masked and read-only paths must be absolute, got '%s'", p)
+		}
+	}
+	if child.Dev && (child.Root == "" || child.Namespaces.Mount.Mode != NamespaceNew) {
+		return nil, errors.New("setting up /dev needs a Root and a new mount namespace")
+	}
+
+	userns := child.Namespaces.User.Mode == NamespaceNew
+	if userns && len(child.UidMappings) == 0 && len(child.GidMappings) == 0 {
+		child.UidMappings, child.GidMappings = defaultIDMaps()
+		child.DenySetgroups = child.DenySetgroups || os.Geteuid() != 0
+	}
+
+	configR, configW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer configW.Close()
+
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		configR.Close()
+		return nil, err
+	}
+	defer errR.Close()
+
+	args := []string{initArgv0}
+	files := []*os.File{configR, errW}
+
+	// with a new user namespace the child waits on this pipe for its id maps
+	var syncW *os.File
+	if userns {
+		var syncR *os.File
+		if syncR, syncW, err = os.Pipe(); err != nil {
+			configR.Close()
+			errW.Close()
+			return nil, err
+		}
+		defer syncR.Close()
+		defer syncW.Close()
+
+		args = append(args, initUsernsArg)
+		files = append(files, syncR)
+	}
+
+	cmd := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       args,
+		Stdin:      cfg.Stdin,
+		Stdout:     cfg.Stdout,
+		Stderr:     cfg.Stderr,
+		ExtraFiles: files,
+		SysProcAttr: &syscall.SysProcAttr{
+			// time and cgroup namespaces are unshared by the init stage
+			Cloneflags: uintptr(child.Namespaces.CloneFlags() &^ (CLONE_NEWTIME | CLONE_NEWCGROUP)),
+		},
+	}
+
+	err = startWithNamespaces(cmd, child.Namespaces)
+	configR.Close()
+	errW.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	c := Container{
+		Pid:    cmd.Process.Pid,
+		Config: cfg,
+		cmd:    cmd,
+		reaped: make(chan struct{}),
+	}
+
+	// our child can't be reaped behind our back, so this is the right process
+	c.pidfd, _ = OpenPidfd(c.Pid)
+
+	// a discarded overlay has to outlive everything in the container's mount namespace
+	if cfg.Overlay != nil && cfg.Overlay.Discard {
+		if proc, err := NewProcess(c.Pid); err == nil {
+			if ns, err := proc.Namespaces(); err == nil {
+				c.mntNs = ns["mnt"]
+			}
+		}
+	}
+
+	if userns {
+		if err = writeIDMaps(c.Pid, child.UidMappings, child.GidMappings, child.DenySetgroups); err != nil {
+			c.abort()
+			return nil, err
+		}
+		syncW.Close()
+	}
+
+	// before the config is sent, the init stage creates the cgroup namespace once it has it
+	if cfg.Cgroup != nil {
+		cfg.Cgroup.AddProcess(c.Pid)
+	}
+
+	if err = json.NewEncoder(configW).Encode(&child); err != nil {
+		c.abort()
+		return nil, err
+	}
+	configW.Close()
+
+	// the error pipe is close-on-exec in the child, so EOF with no message
+	// means the command was exec'd successfully
+	msg, err := ioutil.ReadAll(errR)
+	if err != nil {
+		c.abort()
+		return nil, err
+	}
+	if len(msg) > 0 {
+		c.Wait()
+		return nil, errors.New("container setup failed: " + strings.TrimSpace(string(msg)))
+	}
+
+	return &c, nil
+}
+
+// wait for the container's command to exit. A command that fails or is killed is
+// not an error, that's in the Result. With Config.Init, the Result is the init's,
+// which exits with the command's status, and Rusage also covers reaped orphans.
+func (c *Container) Wait() (*Result, error) {
+	<-c.reap()
+	return c.result, c.waitErr
+}
+
+// reap the command in the background the first time it's called, the returned
+// channel is closed once the result is in
+func (c *Container) reap() <-chan struct{} {
+	c.reapOnce.Do(func() {
+		go func() {
+			c.result, c.waitErr = c.wait()
+			close(c.reaped)
+		}()
+	})
+	return c.reaped
+}
+
+func (c *Container) wait() (*Result, error) {
+	err := c.cmd.Wait()
+	if c.pidfd != nil {
+		c.pidfd.Close()
+	}
+	if c.cmd.ProcessState == nil {
+		return nil, err
+	}
+
+	// ExitError only says the command didn't exit 0, other errors are about its output
+	if _, ok := err.(*exec.ExitError); ok {
+		err = nil
+	}
+
+	if ov := c.Config.Overlay; ov != nil && ov.Discard {
+		if derr := ov.discard(c.Config.Root, c.mntNs); derr != nil && err == nil {
+			err = derr
+		}
+	}
+
+	return ProcessResult(c.cmd.ProcessState), err
+}
+
+// wait like Wait, but give up when ctx is done and return ctx.Err(). The container
+// keeps running then and can be waited for again.
+func (c *Container) WaitContext(ctx context.Context) (*Result, error) {
+	// with a pidfd nothing is left reaping in the background when ctx is done first.
+	// Any other error, e.g. a pidfd closed by an earlier Wait, is left to reap().
+	if c.pidfd != nil {
+		if err := c.pidfd.Wait(ctx); err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case <-c.reap():
+		return c.result, c.waitErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send a signal to the container's command. With a pidfd this can't hit another
+// process that got the same pid after the container was reaped.
+func (c *Container) Signal(sig os.Signal) error {
+	if c.pidfd != nil {
+		return c.pidfd.Signal(sig)
+	}
+	return c.cmd.Process.Signal(sig)
+}
+
+// true until the container's command exits
+func (c *Container) Alive() bool {
+	if c.pidfd != nil {
+		return c.pidfd.Alive()
+	}
+	select {
+	case <-c.reaped:
+		return false
+	default:
+		return c.cmd.Process.Signal(syscall.Signal(0)) == nil
+	}
+}
+
+// the container's pidfd, nil if the kernel doesn't support them. It's closed by Wait.
+func (c *Container) Pidfd() *Pidfd {
+	return c.pidfd
+}
+
+// kill and reap a container that never finished its setup
+func (c *Container) abort() {
+	c.cmd.Process.Kill()
+	c.Wait()
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
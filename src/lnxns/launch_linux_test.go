@@ -0,0 +1,180 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// the launcher re-executes the test binary to set up containers
+func TestMain(m *testing.M) {
+	lnxns.Init()
+	os.Exit(m.Run())
+}
+
+// launch a command or skip the test when namespaces aren't available to us
+func launchOrSkip(t *testing.T, cfg *lnxns.Config) *lnxns.Container {
+	if os.Geteuid() != 0 {
+		t.Skip("launching containers requires root")
+	}
+
+	c, err := lnxns.Launch(cfg)
+	if err != nil && strings.Contains(err.Error(), "operation not permitted") {
+		t.Skipf("namespaces are not available: %s", err)
+	} else if err != nil {
+		t.Fatalf("Launch returned an error! '%s'", err)
+	}
+
+	return c
+}
+
+// a minimal root filesystem for containers: the host's /usr and friends bind-mounted
+// read-only, plus empty directories for everything else. Call cleanup when done.
+func testRootfs(t *testing.T, dirs ...string) (root string, cleanup func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("building a test rootfs requires root")
+	}
+
+	root, err := ioutil.TempDir("", "lnxns-rootfs-")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+
+	var binds []string
+	cleanup = func() {
+		for _, b := range binds {
+			// never remove files through a bind mount that is still there
+			if err := syscall.Unmount(b, syscall.MNT_DETACH); err != nil {
+				t.Errorf("could not unmount %s, leaving %s behind: %s", b, root, err)
+				return
+			}
+		}
+		os.RemoveAll(root)
+	}
+
+	for _, name := range []string{"bin", "lib", "lib32", "lib64", "sbin", "usr"} {
+		host := path.Join("/", name)
+		fi, err := os.Lstat(host)
+		if err != nil {
+			continue
+		}
+
+		// merged /usr systems have symlinks like bin -> usr/bin
+		if fi.Mode()&os.ModeSymlink != 0 {
+			dest, _ := os.Readlink(host)
+			os.Symlink(dest, path.Join(root, name))
+			continue
+		}
+
+		target := path.Join(root, name)
+		os.Mkdir(target, 0755)
+		if err = syscall.Mount(host, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			cleanup()
+			t.Fatalf("could not bind %s to %s: %s", host, target, err)
+		}
+		binds = append(binds, target)
+		syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+	}
+
+	for _, dir := range append([]string{"dev", "etc", "proc", "sys", "tmp"}, dirs...) {
+		os.MkdirAll(path.Join(root, dir), 0755)
+	}
+
+	return root, cleanup
+}
+
+func TestLaunch(t *testing.T) {
+	var out bytes.Buffer
+
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:   "sh",
+		Args:   []string{"sh", "-c", "echo $$ $FOO; pwd"},
+		Env:    []string{"FOO=bar", "PATH=/usr/bin:/bin"},
+		Dir:    "/tmp",
+		Stdout: &out,
+	})
+
+	res, err := c.Wait()
+	if err != nil || !res.Success() {
+		t.Fatalf("Wait returned an error! '%v', '%v'", res, err)
+	}
+
+	// the shell is pid 1 of its own pid namespace
+	if out.String() != "1 bar\n/tmp\n" {
+		t.Fatalf("container output was wrong, Got: '%s'", out.String())
+	}
+
+	_, err = lnxns.Launch(&lnxns.Config{Path: "/does/not/exist"})
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Fatalf("Launch of a missing program should fail from inside the container, Got: '%v'", err)
+	}
+
+	_, err = lnxns.Launch(&lnxns.Config{Path: "true", Root: "/does/not/exist"})
+	if err == nil || !strings.Contains(err.Error(), "/does/not/exist") {
+		t.Fatalf("Launch with a missing root should fail, Got: '%v'", err)
+	}
+}
+
+func TestLaunchResult(t *testing.T) {
+	c := launchOrSkip(t, &lnxns.Config{Path: "sh", Args: []string{"sh", "-c", "exit 7"}})
+	res, err := c.Wait()
+	if err != nil || res.ExitCode != 7 || res.Signal != 0 || res.Status() != 7 || res.Success() {
+		t.Fatalf("wrong result for exit 7, Got: %+v, '%v'", res, err)
+	}
+	if res.Rusage == nil {
+		t.Fatalf("no rusage in the result")
+	}
+
+	c = launchOrSkip(t, &lnxns.Config{Path: "sleep", Args: []string{"sleep", "10"}})
+	c.Signal(syscall.SIGKILL)
+	res, err = c.Wait()
+	if err != nil || res.Signal != syscall.SIGKILL || res.Status() != 137 || res.String() != "signal: killed" {
+		t.Fatalf("wrong result for SIGKILL, Got: %+v (%s), '%v'", res, res, err)
+	}
+
+	// the same for a plain os/exec command, as used by nsexec
+	cmd := exec.Command("sh", "-c", "kill -TERM $$")
+	cmd.Run()
+	res = lnxns.ProcessResult(cmd.ProcessState)
+	if res.Signal != syscall.SIGTERM || res.Status() != 143 || res.Rusage == nil {
+		t.Fatalf("wrong result for an os/exec command, Got: %+v (%s)", res, res)
+	}
+}
+
+func TestLaunchHostname(t *testing.T) {
+	var out bytes.Buffer
+
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:       "cat",
+		Args:       []string{"cat", "/proc/sys/kernel/hostname", "/proc/sys/kernel/domainname"},
+		Hostname:   "lnxns-test",
+		Domainname: "lnxns.example",
+		Stdout:     &out,
+	})
+	c.Wait()
+
+	if out.String() != "lnxns-test\nlnxns.example\n" {
+		t.Fatalf("hostname and domainname were not set, Got: '%s'", out.String())
+	}
+
+	_, err := lnxns.Launch(&lnxns.Config{
+		Path:       "true",
+		Hostname:   "lnxns-test",
+		Namespaces: &lnxns.NamespaceSpec{Net: lnxns.NewNamespace()},
+	})
+	if err == nil {
+		t.Fatalf("Launch setting a hostname without a new UTS namespace should fail.")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,319 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// whiteout files in image layers as described in the OCI image spec, .wh.name
+// deletes name from the layers below and .wh..wh..opq hides everything that was
+// in its directory
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// extract a tar layer, optionally gzipped, into dir on top of what is already
+// there. Ownership, permissions, xattrs, device nodes, hardlinks and timestamps are
+// preserved as far as we are allowed to: without root, ownership, device nodes and
+// privileged xattrs are skipped. Entries that would end up outside of dir are an error.
+func ApplyLayer(dir string, r io.Reader) error {
+	r, err := gunzipLayer(r)
+	if err != nil {
+		return err
+	}
+
+	// paths from this layer, an opaque whiteout must only hide the lower layers
+	written := make(map[string]bool)
+	// directory modes and times are set last, extracting into them changes their
+	// times and a read-only one would keep us from filling it without root
+	var dirs []*tar.Header
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// pax global headers, e.g. the commit id from git archive, aren't files
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		name, err := layerPath(hdr.Name)
+		if err != nil {
+			return err
+		} else if name == "/" {
+			// the layer's root is dir itself, only its metadata applies
+			if hdr.Typeflag == tar.TypeDir {
+				if err = finishEntry(dir, hdr, nil); err != nil {
+					return err
+				}
+				dirs = append(dirs, hdr)
+			}
+			continue
+		}
+
+		parent, err := resolveInRoot(dir, path.Dir(name))
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(parent, 0755); err != nil {
+			return err
+		}
+
+		base := path.Base(name)
+		if base == whiteoutOpaque {
+			if err = removeOpaque(dir, path.Dir(name), written); err != nil {
+				return err
+			}
+			continue
+		} else if strings.HasPrefix(base, whiteoutPrefix) {
+			// the victim is a name in the already resolved parent, .wh.. and .wh...
+			// would take out the parent or its parent. The victim itself isn't
+			// followed, a whited out symlink goes rather than what it points to.
+			victim := base[len(whiteoutPrefix):]
+			if victim == "" || victim == "." || victim == ".." || strings.Contains(victim, "/") {
+				return fmt.Errorf("%s: invalid whiteout", hdr.Name)
+			}
+			if err = os.RemoveAll(path.Join(parent, victim)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		target := path.Join(parent, base)
+		if err = extractEntry(dir, target, hdr, tr); err != nil {
+			return fmt.Errorf("%s: %s", hdr.Name, err)
+		}
+		written[name] = true
+
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name, _ := layerPath(dirs[i].Name)
+		target, err := resolveInRoot(dir, name)
+		if err != nil {
+			return err
+		}
+		if err = syscall.Chmod(target, uint32(dirs[i].Mode&07777)); err != nil {
+			return err
+		}
+		if err = lutimes(target, dirs[i].AccessTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// r, or what it decompresses to when it is gzipped
+func gunzipLayer(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// the path of an entry as an absolute path in the layer, leading slashes are
+// ignored like tar(1) does but .. can't climb out of the layer
+func layerPath(name string) (string, error) {
+	rel := path.Clean(strings.TrimLeft(name, "/"))
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("refusing to extract '%s' outside of the layer", name)
+	}
+	return path.Join("/", rel), nil
+}
+
+// delete the contents of dir that don't come from the current layer
+func removeOpaque(root, dir string, written map[string]bool) error {
+	resolved, err := resolveInRoot(root, dir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !written[path.Join(dir, name)] {
+			if err = os.RemoveAll(path.Join(resolved, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// create one file, directory, link or node from the tar stream at target
+func extractEntry(root, target string, hdr *tar.Header, r io.Reader) error {
+	// anything in the way is replaced, except a directory by a directory
+	if st, err := os.Lstat(target); err == nil && !(st.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err = os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		return finishEntry(target, hdr, os.Symlink(hdr.Linkname, target))
+
+	case tar.TypeLink:
+		name, err := layerPath(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		// link to a symlink itself rather than what it points to
+		parent, err := resolveInRoot(root, path.Dir(name))
+		if err != nil {
+			return err
+		}
+		// a hardlink shares its inode, there's nothing more to set
+		return os.Link(path.Join(parent, path.Base(name)), target)
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		kind := uint32(syscall.S_IFIFO)
+		if hdr.Typeflag == tar.TypeChar {
+			kind = syscall.S_IFCHR
+		} else if hdr.Typeflag == tar.TypeBlock {
+			kind = syscall.S_IFBLK
+		}
+		err := syscall.Mknod(target, kind|mode, mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))
+		if err == syscall.EPERM && kind != syscall.S_IFIFO {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unsupported tar entry type '%c'", hdr.Typeflag)
+	}
+
+	return finishEntry(target, hdr, nil)
+}
+
+// set ownership, xattrs, permissions and times, in that order since chown clears
+// the setuid and setgid bits. A directory only gets its ownership and xattrs here,
+// ApplyLayer does the rest once it's filled.
+func finishEntry(target string, hdr *tar.Header, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if err = syscall.Lchown(target, hdr.Uid, hdr.Gid); err != nil && err != syscall.EPERM {
+		return err
+	}
+
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, "SCHILY.xattr.") {
+			continue
+		}
+		err = lsetxattr(target, key[len("SCHILY.xattr."):], []byte(value))
+		if err != nil && err != syscall.EPERM && err != syscall.ENOTSUP {
+			return err
+		}
+	}
+
+	if hdr.Typeflag == tar.TypeSymlink {
+		return lutimes(target, hdr.AccessTime, hdr.ModTime)
+	} else if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+
+	if err = syscall.Chmod(target, uint32(hdr.Mode&07777)); err != nil {
+		return err
+	}
+	return lutimes(target, hdr.AccessTime, hdr.ModTime)
+}
+
+// lsetxattr(2), which the syscall package doesn't have
+func lsetxattr(p, attr string, data []byte) error {
+	pp, err := syscall.BytePtrFromString(p)
+	if err != nil {
+		return err
+	}
+	ap, err := syscall.BytePtrFromString(attr)
+	if err != nil {
+		return err
+	}
+
+	var dp unsafe.Pointer
+	if len(data) > 0 {
+		dp = unsafe.Pointer(&data[0])
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_LSETXATTR, uintptr(unsafe.Pointer(pp)), uintptr(unsafe.Pointer(ap)),
+		uintptr(dp), uintptr(len(data)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// set the times of a file without following symlinks, a zero atime means mtime
+func lutimes(p string, atime, mtime time.Time) error {
+	if atime.IsZero() {
+		atime = mtime
+	}
+	ts := [2]syscall.Timespec{syscall.NsecToTimespec(atime.UnixNano()), syscall.NsecToTimespec(mtime.UnixNano())}
+
+	pp, err := syscall.BytePtrFromString(p)
+	if err != nil {
+		return err
+	}
+	cwd := AT_FDCWD
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(cwd), uintptr(unsafe.Pointer(pp)),
+		uintptr(unsafe.Pointer(&ts)), AT_SYMLINK_NOFOLLOW, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,256 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// a tarball of hdrs, a regular file's content is its Linkname for brevity
+func testTarball(t *testing.T, gz bool, hdrs ...tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, hdr := range hdrs {
+		var data []byte
+		if hdr.Typeflag == tar.TypeReg {
+			data = []byte(hdr.Linkname)
+			hdr.Linkname = ""
+			hdr.Size = int64(len(data))
+		}
+		if hdr.Mode == 0 && hdr.Typeflag != tar.TypeXGlobalHeader {
+			hdr.Mode = 0755
+		}
+		hdr.Format = tar.FormatPAX
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("Could not write a tar header: %s", err)
+		}
+		tw.Write(data)
+	}
+	tw.Close()
+
+	if !gz {
+		return buf.Bytes()
+	}
+	var zbuf bytes.Buffer
+	zw := gzip.NewWriter(&zbuf)
+	zw.Write(buf.Bytes())
+	zw.Close()
+	return zbuf.Bytes()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "lnxns-layer-")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	return dir
+}
+
+func TestApplyLayer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("preserving ownership and device nodes requires root")
+	}
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	mtime := time.Unix(1234567890, 0)
+	base := testTarball(t, true,
+		tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0751},
+		tar.Header{Name: "etc/", Typeflag: tar.TypeDir, ModTime: mtime},
+		tar.Header{Name: "etc/passwd", Typeflag: tar.TypeReg, Linkname: "root\n", Mode: 0644, ModTime: mtime},
+		tar.Header{Name: "bin/su", Typeflag: tar.TypeReg, Linkname: "su", Mode: 04755, Uid: 1000, Gid: 1001,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.lnxns": "yes"}},
+		tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+		tar.Header{Name: "bin/passwd", Typeflag: tar.TypeLink, Linkname: "bin/su"},
+		tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3, Mode: 0666},
+		tar.Header{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0600},
+		tar.Header{Name: "var/cache/a", Typeflag: tar.TypeReg, Linkname: "a"},
+		tar.Header{Name: "var/cache/b", Typeflag: tar.TypeReg, Linkname: "b"},
+	)
+	if err := lnxns.ApplyLayer(dir, bytes.NewReader(base)); err != nil {
+		t.Fatalf("ApplyLayer failed: %s", err)
+	}
+
+	var st syscall.Stat_t
+	syscall.Stat(path.Join(dir, "bin/su"), &st)
+	if st.Uid != 1000 || st.Gid != 1001 || st.Mode&07777 != 04755 || st.Nlink != 2 {
+		t.Fatalf("bin/su was not extracted as it was archived: %+v", st)
+	}
+	xattr := make([]byte, 16)
+	n, err := syscall.Getxattr(path.Join(dir, "bin/su"), "user.lnxns", xattr)
+	if err != syscall.ENOTSUP && (err != nil || string(xattr[:n]) != "yes") {
+		t.Fatalf("the xattr on bin/su was lost: %v '%s'", err, xattr[:n])
+	}
+	if link, _ := os.Readlink(path.Join(dir, "bin/sh")); link != "busybox" {
+		t.Fatalf("bin/sh should be a symlink to busybox, Got: '%s'", link)
+	}
+	syscall.Stat(path.Join(dir, "dev/null"), &st)
+	if st.Mode&syscall.S_IFMT != syscall.S_IFCHR || st.Rdev != 1<<8|3 {
+		t.Fatalf("dev/null is not the null device: %+v", st)
+	}
+	if fi, err := os.Stat(path.Join(dir, "run/fifo")); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatalf("run/fifo is not a fifo: %v %v", err, fi)
+	}
+	if fi, _ := os.Stat(dir); fi.Mode().Perm() != 0751 {
+		t.Fatalf("the ./ entry should set the mode of the layer's root, Got: %s", fi.Mode())
+	}
+	if fi, _ := os.Stat(path.Join(dir, "etc")); !fi.ModTime().Equal(mtime) {
+		t.Fatalf("the mtime of etc was not kept, Got: %s", fi.ModTime())
+	}
+
+	// a layer on top deletes a file and replaces a directory's contents
+	top := testTarball(t, false,
+		tar.Header{Name: "etc/.wh.passwd", Typeflag: tar.TypeReg},
+		tar.Header{Name: "var/cache/c", Typeflag: tar.TypeReg, Linkname: "c"},
+		tar.Header{Name: "var/cache/.wh..wh..opq", Typeflag: tar.TypeReg},
+	)
+	if err = lnxns.ApplyLayer(dir, bytes.NewReader(top)); err != nil {
+		t.Fatalf("ApplyLayer failed: %s", err)
+	}
+
+	if _, err = os.Lstat(path.Join(dir, "etc/passwd")); !os.IsNotExist(err) {
+		t.Fatalf("etc/passwd should have been whited out: %v", err)
+	}
+	names, _ := ioutil.ReadDir(path.Join(dir, "var/cache"))
+	if len(names) != 1 || names[0].Name() != "c" {
+		t.Fatalf("var/cache should only have c after the opaque whiteout, Got: %v", names)
+	}
+}
+
+func TestApplyLayerReadonlyDir(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// without root, a 0555 directory can only be filled before it gets its mode
+	layer := testTarball(t, false,
+		tar.Header{Name: "ro/", Typeflag: tar.TypeDir, Mode: 0555},
+		tar.Header{Name: "ro/sub/", Typeflag: tar.TypeDir, Mode: 0500},
+		tar.Header{Name: "ro/sub/file", Typeflag: tar.TypeReg, Linkname: "file", Mode: 0444},
+	)
+	if err := lnxns.ApplyLayer(dir, bytes.NewReader(layer)); err != nil {
+		t.Fatalf("ApplyLayer failed: %s", err)
+	}
+	defer os.Chmod(path.Join(dir, "ro/sub"), 0700)
+	defer os.Chmod(path.Join(dir, "ro"), 0700)
+
+	if fi, _ := os.Stat(path.Join(dir, "ro")); fi.Mode().Perm() != 0555 {
+		t.Fatalf("ro should be 0555, Got: %s", fi.Mode())
+	}
+	if fi, _ := os.Stat(path.Join(dir, "ro/sub")); fi.Mode().Perm() != 0500 {
+		t.Fatalf("ro/sub should be 0500, Got: %s", fi.Mode())
+	}
+	if data, _ := ioutil.ReadFile(path.Join(dir, "ro/sub/file")); string(data) != "file" {
+		t.Fatalf("ro/sub/file was not extracted, Got: '%s'", data)
+	}
+}
+
+func TestApplyLayerTraversal(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	outside := tempDir(t)
+	defer os.RemoveAll(outside)
+
+	bad := testTarball(t, false, tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Linkname: "evil"})
+	if err := lnxns.ApplyLayer(dir, bytes.NewReader(bad)); err == nil {
+		t.Fatalf("ApplyLayer should refuse to write outside of its directory")
+	}
+
+	// writing through an absolute symlink stays inside dir too
+	sneaky := testTarball(t, false,
+		tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: outside},
+		tar.Header{Name: "escape/evil", Typeflag: tar.TypeReg, Linkname: "evil"},
+	)
+	if err := lnxns.ApplyLayer(dir, bytes.NewReader(sneaky)); err != nil {
+		t.Fatalf("ApplyLayer failed: %s", err)
+	}
+	if _, err := os.Stat(path.Join(outside, "evil")); err == nil {
+		t.Fatalf("ApplyLayer followed a symlink out of its directory")
+	}
+	if _, err := os.Stat(path.Join(dir, outside, "evil")); err != nil {
+		t.Fatalf("the file behind the symlink should be inside the layer: %s", err)
+	}
+}
+
+func TestApplyLayerBadWhiteout(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(path.Join(dir, "a/b"), 0755)
+	ioutil.WriteFile(path.Join(dir, "a/b/keep"), []byte("keep"), 0644)
+
+	// .wh.. would delete a/b itself and .wh... its parent
+	for _, name := range []string{"a/b/.wh..", "a/b/.wh..."} {
+		bad := testTarball(t, false, tar.Header{Name: name, Typeflag: tar.TypeReg})
+		if err := lnxns.ApplyLayer(dir, bytes.NewReader(bad)); err == nil {
+			t.Fatalf("ApplyLayer should refuse the whiteout %s", name)
+		}
+		if _, err := os.Stat(path.Join(dir, "a/b/keep")); err != nil {
+			t.Fatalf("the whiteout %s removed more than a name in its directory: %s", name, err)
+		}
+	}
+}
+
+func TestApplyLayerGlobalHeader(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// what git archive puts first
+	layer := testTarball(t, false,
+		tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader,
+			PAXRecords: map[string]string{"comment": "0123456789abcdef0123456789abcdef01234567"}},
+		tar.Header{Name: "LICENSE", Typeflag: tar.TypeReg, Linkname: "BSD\n", Mode: 0644},
+	)
+	if err := lnxns.ApplyLayer(dir, bytes.NewReader(layer)); err != nil {
+		t.Fatalf("ApplyLayer failed on a pax global header: %s", err)
+	}
+
+	if data, err := ioutil.ReadFile(path.Join(dir, "LICENSE")); err != nil || string(data) != "BSD\n" {
+		t.Fatalf("ApplyLayer did not extract the file after the global header, Got: '%s', '%v'", data, err)
+	}
+	if _, err := os.Lstat(path.Join(dir, "pax_global_header")); !os.IsNotExist(err) {
+		t.Fatalf("ApplyLayer extracted the pax global header as a file: %v", err)
+	}
+}
+
+func TestUnpackRootfs(t *testing.T) {
+	cache := tempDir(t)
+	defer os.RemoveAll(cache)
+
+	tarball := path.Join(cache, "rootfs.tar.gz")
+	ioutil.WriteFile(tarball, testTarball(t, true,
+		tar.Header{Name: "etc/hostname", Typeflag: tar.TypeReg, Linkname: "lnxns\n", Mode: 0644},
+	), 0644)
+
+	rootfs, err := lnxns.UnpackRootfs(path.Join(cache, "rootfs"), tarball)
+	if err != nil {
+		t.Fatalf("UnpackRootfs failed: %s", err)
+	}
+	if data, _ := ioutil.ReadFile(path.Join(rootfs, "etc/hostname")); string(data) != "lnxns\n" {
+		t.Fatalf("the tarball was not unpacked, Got: '%s'", data)
+	}
+
+	// the second time it comes from the cache
+	os.Remove(path.Join(rootfs, "etc/hostname"))
+	again, err := lnxns.UnpackRootfs(path.Join(cache, "rootfs"), tarball)
+	if err != nil || again != rootfs {
+		t.Fatalf("UnpackRootfs did not reuse %s: %v %s", rootfs, err, again)
+	}
+	if _, err = os.Stat(path.Join(rootfs, "etc/hostname")); err == nil {
+		t.Fatalf("UnpackRootfs unpacked the same tarball twice")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,313 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// MountInfo is one line of /proc/<pid>/mountinfo, see proc(5).
+type MountInfo struct {
+	ID           int
+	ParentID     int
+	Major        int
+	Minor        int
+	Root         string
+	Mountpoint   string
+	Options      []string
+	Optional     []string // e.g. shared:1 or master:2
+	Filesystem   string
+	Source       string
+	SuperOptions []string
+}
+
+type MountEventType int
+
+const (
+	MountAdded MountEventType = iota
+	MountRemoved
+	MountChanged
+)
+
+// MountEvent describes one difference between two snapshots of a mount table.
+// Previous is only set for MountChanged.
+type MountEvent struct {
+	Type     MountEventType
+	Mount    *MountInfo
+	Previous *MountInfo
+}
+
+// MountWatcher delivers MountEvents for a mount namespace as mounts come and go.
+// Events is closed when the watcher stops, check Err() to see why.
+type MountWatcher struct {
+	Events chan MountEvent
+	fd     int
+	ep     *epoller
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+func (t MountEventType) String() string {
+	switch t {
+	case MountAdded:
+		return "added"
+	case MountRemoved:
+		return "removed"
+	case MountChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// parse a mountinfo file, e.g. ProcFs().GetMountInfo("self/mountinfo")
+func (vfs *Vfs) GetMountInfo(name string) (mounts []*MountInfo, err error) {
+	var perr error
+
+	parser := func(line string) {
+		mi, lerr := parseMountInfoLine(line)
+		if lerr != nil {
+			perr = lerr
+			return
+		}
+		mounts = append(mounts, mi)
+	}
+
+	if err = vfs.slurpLines(name, parser); err == nil {
+		err = perr
+	}
+	return
+}
+
+// the mount table of the process's mount namespace
+func (p *Process) MountInfo() ([]*MountInfo, error) {
+	return p.vfs.GetMountInfo("mountinfo")
+}
+
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfoLine(line string) (*MountInfo, error) {
+	parts := strings.Fields(line)
+
+	// the optional fields end with a lone "-"
+	sep := -1
+	for i := 6; i < len(parts); i++ {
+		if parts[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if len(parts) < 7 || sep < 0 || len(parts) < sep+3 {
+		return nil, fmt.Errorf("unexpected line in mountinfo: '%s'", line)
+	}
+
+	var mi MountInfo
+	var err error
+
+	if mi.ID, err = strconv.Atoi(parts[0]); err != nil {
+		return nil, fmt.Errorf("bad mount id in mountinfo line '%s': %s", line, err)
+	}
+	if mi.ParentID, err = strconv.Atoi(parts[1]); err != nil {
+		return nil, fmt.Errorf("bad parent id in mountinfo line '%s': %s", line, err)
+	}
+	if _, err = fmt.Sscanf(parts[2], "%d:%d", &mi.Major, &mi.Minor); err != nil {
+		return nil, fmt.Errorf("bad device in mountinfo line '%s': %s", line, err)
+	}
+
+	mi.Root = unescapeMountPath(parts[3])
+	mi.Mountpoint = unescapeMountPath(parts[4])
+	mi.Options = strings.Split(parts[5], ",")
+	mi.Optional = parts[6:sep]
+	mi.Filesystem = parts[sep+1]
+	mi.Source = unescapeMountPath(parts[sep+2])
+	if len(parts) > sep+3 {
+		mi.SuperOptions = strings.Split(parts[sep+3], ",")
+	}
+
+	return &mi, nil
+}
+
+// the kernel escapes space, tab, newline and backslash as \ooo octal
+func unescapeMountPath(p string) string {
+	if !strings.Contains(p, "\\") {
+		return p
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+4 <= len(p) {
+			if c, err := strconv.ParseUint(p[i+1:i+4], 8, 8); err == nil {
+				out.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		out.WriteByte(p[i])
+	}
+
+	return out.String()
+}
+
+// start watching the mount namespace of pid for mount and unmount events,
+// use os.Getpid() for the caller's own namespace
+func WatchMounts(pid int) (*MountWatcher, error) {
+	// os.Open would register the fd with the runtime's netpoller, whose own poll
+	// calls would swallow the change notifications, so use a raw fd
+	mpath := path.Join(ProcFs().Path(), strconv.Itoa(pid), "mountinfo")
+	fd, err := syscall.Open(mpath, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: mpath, Err: err}
+	}
+
+	ep, err := newEpoller()
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// the kernel flags mountinfo with POLLPRI|POLLERR whenever the table changes
+	if err = ep.add(fd, syscall.EPOLLPRI|syscall.EPOLLERR); err != nil {
+		ep.close()
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	mw := MountWatcher{
+		Events: make(chan MountEvent, 16),
+		fd:     fd,
+		ep:     ep,
+		done:   make(chan struct{}),
+	}
+
+	// take the first snapshot before returning so no change after this point is missed
+	current, err := mw.snapshot()
+	if err != nil {
+		mw.cleanup()
+		return nil, err
+	}
+
+	go mw.loop(current)
+
+	return &mw, nil
+}
+
+// stop watching, Events is closed once the watcher goroutine exits
+func (mw *MountWatcher) Close() error {
+	mw.once.Do(func() {
+		close(mw.done)
+		mw.ep.wake()
+	})
+	return nil
+}
+
+// the error that stopped the watcher, nil after a normal Close()
+func (mw *MountWatcher) Err() error {
+	return mw.err
+}
+
+func (mw *MountWatcher) loop(current map[int]*MountInfo) {
+	defer close(mw.Events)
+	defer mw.cleanup()
+
+	for {
+		ready, woken, err := mw.ep.wait(-1)
+		if err != nil {
+			mw.err = err
+			return
+		}
+		if woken {
+			return
+		}
+		if len(ready) == 0 {
+			continue
+		}
+
+		next, err := mw.snapshot()
+		if err != nil {
+			mw.err = err
+			return
+		}
+
+		for _, ev := range diffMounts(current, next) {
+			select {
+			case mw.Events <- ev:
+			case <-mw.done:
+				return
+			}
+		}
+		current = next
+	}
+}
+
+// re-read the whole table from the already open file, keyed by mount id
+func (mw *MountWatcher) snapshot() (map[int]*MountInfo, error) {
+	if _, err := syscall.Seek(mw.fd, 0, 0); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	buf := make([]byte, 16384)
+	for {
+		n, err := syscall.Read(mw.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		data = append(data, buf[:n]...)
+	}
+
+	table := make(map[int]*MountInfo)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		mi, err := parseMountInfoLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		table[mi.ID] = mi
+	}
+
+	return table, scanner.Err()
+}
+
+func (mw *MountWatcher) cleanup() {
+	mw.ep.close()
+	syscall.Close(mw.fd)
+}
+
+// compare two snapshots keyed by mount id. Mount ids are recycled, so an id that now
+// points at a different mountpoint or device is reported as removed + added.
+func diffMounts(old, new map[int]*MountInfo) (events []MountEvent) {
+	for id, prev := range old {
+		mi, ok := new[id]
+		if !ok || mi.Mountpoint != prev.Mountpoint || mi.Major != prev.Major || mi.Minor != prev.Minor {
+			events = append(events, MountEvent{Type: MountRemoved, Mount: prev})
+		}
+	}
+
+	for id, mi := range new {
+		prev, ok := old[id]
+		if !ok || mi.Mountpoint != prev.Mountpoint || mi.Major != prev.Major || mi.Minor != prev.Minor {
+			events = append(events, MountEvent{Type: MountAdded, Mount: mi})
+		} else if !reflect.DeepEqual(mi, prev) {
+			events = append(events, MountEvent{Type: MountChanged, Mount: mi, Previous: prev})
+		}
+	}
+
+	return
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
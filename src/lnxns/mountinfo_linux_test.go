@@ -0,0 +1,99 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGetMountInfo(t *testing.T) {
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-mountinfo")
+	defer os.RemoveAll(tmpPath)
+
+	vr, _ := lnxns.NewVfs(tmpPath)
+	vr.SetString("mountinfo", "36 35 98:0 /mnt1 /mnt\\0402 rw,noatime master:1 shared:2 - ext3 /dev/root rw,errors=continue\n"+
+		"23 28 0:22 / /proc rw,relatime - proc proc rw")
+
+	mounts, err := vr.GetMountInfo("mountinfo")
+	if err != nil {
+		t.Fatalf("GetMountInfo returned an error! '%s'", err)
+	}
+	if len(mounts) != 2 {
+		t.Fatalf("GetMountInfo failed, Got %d mounts", len(mounts))
+	}
+
+	mi := mounts[0]
+	if mi.ID != 36 || mi.ParentID != 35 || mi.Major != 98 || mi.Minor != 0 || mi.Root != "/mnt1" ||
+		mi.Mountpoint != "/mnt 2" || len(mi.Optional) != 2 || mi.Filesystem != "ext3" ||
+		mi.Source != "/dev/root" || mi.SuperOptions[1] != "errors=continue" {
+		t.Fatalf("GetMountInfo failed, Got: '%+v'", mi)
+	}
+	if len(mounts[1].Optional) != 0 || mounts[1].Filesystem != "proc" {
+		t.Fatalf("GetMountInfo failed, Got: '%+v'", mounts[1])
+	}
+
+	vr.SetString("bad", "36 35 98:0 /mnt1 /mnt2 rw master:1 ext3 /dev/root rw")
+	if _, err = vr.GetMountInfo("bad"); err == nil {
+		t.Fatalf("GetMountInfo returned a nil error where a real error was expected.")
+	}
+}
+
+func TestWatchMounts(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting requires root")
+	}
+
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-watchmounts")
+	defer os.RemoveAll(tmpPath)
+
+	mw, err := lnxns.WatchMounts(os.Getpid())
+	if err != nil {
+		t.Fatalf("WatchMounts returned an error! '%s'", err)
+	}
+	defer mw.Close()
+
+	if err = syscall.Mount("tmpfs", tmpPath, "tmpfs", 0, ""); err != nil {
+		t.Skipf("could not mount a tmpfs: %s", err)
+	}
+	defer syscall.Unmount(tmpPath, syscall.MNT_DETACH)
+
+	expect := func(what lnxns.MountEventType) {
+		for {
+			select {
+			case ev := <-mw.Events:
+				if ev.Mount.Mountpoint == tmpPath && ev.Type == what {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for a %s event on %s", what, tmpPath)
+			}
+		}
+	}
+
+	expect(lnxns.MountAdded)
+
+	// a per-mount flag change, superblock remounts don't wake up pollers
+	if err = syscall.Mount("", tmpPath, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY, ""); err != nil {
+		t.Fatalf("remount failed: %s", err)
+	}
+	expect(lnxns.MountChanged)
+
+	syscall.Unmount(tmpPath, 0)
+	expect(lnxns.MountRemoved)
+
+	mw.Close()
+	for _ = range mw.Events {
+	}
+	if mw.Err() != nil {
+		t.Fatalf("watcher stopped with an error: %s", mw.Err())
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,155 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+)
+
+type NamespaceMode int
+
+const (
+	NamespaceHost NamespaceMode = iota // share the launcher's namespace, the zero value
+	NamespaceNew                       // create a new namespace
+	NamespaceJoin                      // join an existing namespace by Path or Pid
+)
+
+// Namespace is the choice made for one kind of namespace in a NamespaceSpec.
+// To join, set Path (e.g. /run/netns/blue) or Pid to use /proc/<pid>/ns/<type>.
+// Launch re-executes itself through /proc/self/exe, so a joined mount namespace
+// must have /proc mounted.
+type Namespace struct {
+	Mode NamespaceMode
+	Path string
+	Pid  int
+}
+
+// NamespaceSpec picks new, host or joined namespaces for a container.
+// The zero value shares everything with the host, so
+// NamespaceSpec{Net: NewNamespace()} is a network-only sandbox.
+type NamespaceSpec struct {
+	Mount  Namespace
+	Pid    Namespace
+	Uts    Namespace
+	Ipc    Namespace
+	Net    Namespace
+	User   Namespace
+	Cgroup Namespace
+	Time   Namespace
+}
+
+// one entry of a NamespaceSpec along with its name in /proc/<pid>/ns
+type nsEntry struct {
+	name string
+	ns   *Namespace
+}
+
+// a namespace that will be created for the container
+func NewNamespace() Namespace {
+	return Namespace{Mode: NamespaceNew}
+}
+
+// a namespace to join by path, e.g. JoinNamespace("/run/netns/blue")
+func JoinNamespace(p string) Namespace {
+	return Namespace{Mode: NamespaceJoin, Path: p}
+}
+
+// a namespace to join that belongs to a running process
+func JoinNamespacePid(pid int) Namespace {
+	return Namespace{Mode: NamespaceJoin, Pid: pid}
+}
+
+// new mount, pid, uts and ipc namespaces, which is what NsFork has always done
+func DefaultNamespaceSpec() *NamespaceSpec {
+	return &NamespaceSpec{
+		Mount: NewNamespace(),
+		Pid:   NewNamespace(),
+		Uts:   NewNamespace(),
+		Ipc:   NewNamespace(),
+	}
+}
+
+// the CLONE_NEW* flags for every namespace with NamespaceNew, note that clone(2)
+// rejects CLONE_NEWTIME, it only works with unshare(2)
+func (spec *NamespaceSpec) CloneFlags() (flags int) {
+	for _, e := range spec.entries() {
+		if e.ns.Mode == NamespaceNew {
+			flags |= nsFlags[e.name]
+		}
+	}
+	return
+}
+
+// the entries in the order they are joined, mount goes last because setns(2) on a
+// mount namespace also resets the thread's root and working directory
+func (spec *NamespaceSpec) entries() []nsEntry {
+	return []nsEntry{
+		{"user", &spec.User},
+		{"pid", &spec.Pid},
+		{"uts", &spec.Uts},
+		{"ipc", &spec.Ipc},
+		{"net", &spec.Net},
+		{"cgroup", &spec.Cgroup},
+		{"time", &spec.Time},
+		{"mnt", &spec.Mount},
+	}
+}
+
+// the file to pass to setns(2) for a joined namespace
+func (ns *Namespace) nsPath(name string) (string, error) {
+	if ns.Path != "" {
+		return ns.Path, nil
+	}
+	if ns.Pid > 0 {
+		return path.Join(ProcFs().Path(), strconv.Itoa(ns.Pid), "ns", name), nil
+	}
+	return "", fmt.Errorf("no path or pid to join the %s namespace", name)
+}
+
+// check for combinations the launcher can't do
+func (spec *NamespaceSpec) validate() error {
+	for _, e := range spec.entries() {
+		switch e.ns.Mode {
+		case NamespaceHost, NamespaceNew:
+		case NamespaceJoin:
+			if _, err := e.ns.nsPath(e.name); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid mode %d for the %s namespace", e.ns.Mode, e.name)
+		}
+	}
+
+	// setns(2) into a user namespace requires a single-threaded caller, which a Go program never is
+	if spec.User.Mode == NamespaceJoin {
+		return errors.New("joining an existing user namespace is not supported")
+	}
+
+	// same for the time namespace, setns(2) fails with EUSERS
+	if spec.Time.Mode == NamespaceJoin {
+		return errors.New("joining an existing time namespace is not supported")
+	}
+
+	return nil
+}
+
+// start cmd in the joined namespaces of spec, which os/exec can't do on its own.
+// They are entered on a locked thread that cmd is forked from, after which the
+// thread is thrown away.
+func startWithNamespaces(cmd *exec.Cmd, spec *NamespaceSpec) error {
+	for _, e := range spec.entries() {
+		if e.ns.Mode == NamespaceJoin {
+			return spec.onThread(false, cmd.Start)
+		}
+	}
+
+	return cmd.Start()
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
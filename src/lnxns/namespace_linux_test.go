@@ -0,0 +1,96 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNamespaceSpec(t *testing.T) {
+	def := lnxns.DefaultNamespaceSpec().CloneFlags()
+	if def != lnxns.CLONE_NEWNS|lnxns.CLONE_NEWPID|lnxns.CLONE_NEWUTS|lnxns.CLONE_NEWIPC {
+		t.Fatalf("DefaultNamespaceSpec has the wrong flags: %x", def)
+	}
+
+	netOnly := lnxns.NamespaceSpec{Net: lnxns.NewNamespace(), Uts: lnxns.JoinNamespacePid(1)}
+	if netOnly.CloneFlags() != lnxns.CLONE_NEWNET {
+		t.Fatalf("CloneFlags failed, Got: %x", netOnly.CloneFlags())
+	}
+
+	_, err := lnxns.Launch(&lnxns.Config{
+		Path:       "true",
+		Namespaces: &lnxns.NamespaceSpec{User: lnxns.JoinNamespacePid(1)},
+	})
+	if err == nil {
+		t.Fatalf("Launch joining a user namespace should fail.")
+	}
+
+	_, err = lnxns.Launch(&lnxns.Config{
+		Path:       "true",
+		Namespaces: &lnxns.NamespaceSpec{Net: lnxns.Namespace{Mode: lnxns.NamespaceJoin}},
+	})
+	if err == nil {
+		t.Fatalf("Launch joining a namespace without a path or pid should fail.")
+	}
+}
+
+func TestLaunchNamespaces(t *testing.T) {
+	self, _ := lnxns.NewProcess(os.Getpid())
+	ours, _ := self.Namespaces()
+
+	// a network-only sandbox that keeps everything else
+	var out bytes.Buffer
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:       "sh",
+		Args:       []string{"sh", "-c", "readlink /proc/self/ns/net /proc/self/ns/mnt /proc/self/ns/time"},
+		Namespaces: &lnxns.NamespaceSpec{Net: lnxns.NewNamespace(), Time: lnxns.NewNamespace()},
+		Stdout:     &out,
+	})
+	c.Wait()
+
+	links := strings.Fields(out.String())
+	if len(links) != 3 {
+		t.Fatalf("unexpected output from the container: '%s'", out.String())
+	}
+	if links[0] == "net:["+itoa(ours["net"])+"]" || links[1] != "mnt:["+itoa(ours["mnt"])+"]" {
+		t.Fatalf("the container has the wrong namespaces, ours: %v, theirs: %v", ours, links)
+	}
+	if ours["time"] != 0 && links[2] == "time:["+itoa(ours["time"])+"]" {
+		t.Fatalf("the container did not get a new time namespace, ours: %v, theirs: %v", ours, links)
+	}
+
+	// a container with its own hostname, joined by a second one
+	first := launchOrSkip(t, &lnxns.Config{
+		Path: "sh",
+		Args: []string{"sh", "-c", "hostname lnxns-join-test && exec sleep 10"},
+	})
+	defer first.Wait()
+	defer first.Signal(os.Kill)
+
+	out.Reset()
+	for i := 0; i < 100 && out.String() != "lnxns-join-test\n"; i++ {
+		out.Reset()
+		second := launchOrSkip(t, &lnxns.Config{
+			Path:       "hostname",
+			Namespaces: &lnxns.NamespaceSpec{Uts: lnxns.JoinNamespacePid(first.Pid), Mount: lnxns.JoinNamespacePid(first.Pid)},
+			Stdout:     &out,
+		})
+		second.Wait()
+	}
+	if out.String() != "lnxns-join-test\n" {
+		t.Fatalf("joining the uts namespace failed, Got: '%s'", out.String())
+	}
+}
+
+func itoa(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
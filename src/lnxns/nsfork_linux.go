@@ -8,12 +8,17 @@ import (
 	"syscall"
 )
 
+// fork into new mount, pid, uts and ipc namespaces plus more_flags
+//
+// Deprecated: the child of a raw clone from a multithreaded Go program inherits the
+// runtime state of every thread but only has one of them, so anything other than an
+// immediate exec is undefined behavior. Use Launch instead.
 func NsFork(more_flags int) (pid int, err error) {
 	// CLONE_NEWNET unsupported for now
 	// assume the caller wants an isolated process and turn on all namespacing except
 	// for network, which requires setup to get networking going in the container
 	// explicitly avoid CLONE_FS/CLONE_FILES/CLONE_IO and threading-related flags!
-	var flags int = CLONE_NEWNS | CLONE_NEWPID | CLONE_NEWUTS | CLONE_NEWIPC | SIGCHLD | more_flags
+	var flags int = DefaultNamespaceSpec().CloneFlags() | SIGCHLD | more_flags
 
 	// see go/src/pkg/syscall/exec_unix.go
 	syscall.ForkLock.Lock()
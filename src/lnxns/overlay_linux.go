@@ -0,0 +1,134 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Overlay stacks read-only layers into a container's Root with overlayfs, so many
+// containers can share one base directory and each one's writes go to its own
+// upper dir. The mount only exists in the container's mount namespace.
+// After the container exits Upper holds its changes in overlayfs format, deleted
+// files are 0:0 character devices and replaced directories have the opaque xattr.
+type Overlay struct {
+	Lower   []string // read-only layers, the first one is the topmost
+	Upper   string   // where the container's writes go, created when it's missing
+	Work    string   // scratch dir for overlayfs, must be on the same filesystem as Upper
+	Discard bool     // remove Upper and Work when the container has been waited for
+
+	dir string // from NewOverlay, removed by Discard too when it's left empty
+}
+
+// an overlay on top of lower with its upper and work dirs in dir, e.g.
+// cfg.Overlay = lnxns.NewOverlay("/var/lib/build/1", "/srv/base")
+func NewOverlay(dir string, lower ...string) *Overlay {
+	return &Overlay{
+		Lower: lower,
+		Upper: path.Join(dir, "upper"),
+		Work:  path.Join(dir, "work"),
+		dir:   dir,
+	}
+}
+
+func (ov *Overlay) validate() error {
+	if len(ov.Lower) == 0 {
+		return errors.New("an overlay needs at least one lower layer")
+	}
+	if ov.Upper == "" || ov.Work == "" {
+		return errors.New("an overlay needs an upper and a work dir")
+	}
+
+	for _, dir := range append([]string{ov.Upper, ov.Work}, ov.Lower...) {
+		if !path.IsAbs(dir) {
+			return fmt.Errorf("overlay directories must be absolute, got '%s'", dir)
+		}
+		// they're separators in the mount options
+		if strings.ContainsAny(dir, ":,") {
+			return fmt.Errorf("overlay directory '%s' can't contain ':' or ','", dir)
+		}
+	}
+
+	return nil
+}
+
+// mount the overlay on target, in a user namespace overlayfs has to keep its
+// metadata in user.* xattrs since trusted.* ones need real root
+func (ov *Overlay) mount(target string, userns bool) error {
+	for _, dir := range []string{ov.Upper, ov.Work, target} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(ov.Lower, ":"), ov.Upper, ov.Work)
+	if userns {
+		opts += ",userxattr"
+	}
+
+	if err := syscall.Mount("overlay", target, "overlay", 0, opts); err != nil {
+		return fmt.Errorf("could not mount an overlay on %s: %s", target, err)
+	}
+	return nil
+}
+
+// remove the upper and work dirs, the container's changes are lost. The mount point
+// target and the directory given to NewOverlay go too when nothing else is in them.
+// The overlay stays mounted as long as any process is in the container's mount
+// namespace mntNs, e.g. a background child that outlived the command without a new
+// pid namespace, so nothing is removed then. A namespace that is only pinned by a
+// bind mount can't be seen here.
+func (ov *Overlay) discard(target string, mntNs uint64) error {
+	if pid := mountNsUser(mntNs); pid > 0 {
+		return fmt.Errorf("the overlay on %s is still in use by pid %d, not discarding it", target, pid)
+	}
+
+	if err := os.RemoveAll(ov.Upper); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(ov.Work); err != nil {
+		return err
+	}
+
+	os.Remove(target)
+	if ov.dir != "" {
+		os.Remove(ov.dir)
+	}
+	return nil
+}
+
+// a process in the mount namespace with inode ns, 0 when there is none
+func mountNsUser(ns uint64) int {
+	if ns == 0 {
+		return 0
+	}
+
+	f, err := os.Open(ProcFs().Path())
+	if err != nil {
+		return 0
+	}
+	names, _ := f.Readdirnames(-1)
+	f.Close()
+
+	link := fmt.Sprintf("mnt:[%d]", ns)
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		if l, err := os.Readlink(path.Join(ProcFs().Path(), name, "ns", "mnt")); err == nil && l == link {
+			return pid
+		}
+	}
+	return 0
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
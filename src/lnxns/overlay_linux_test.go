@@ -0,0 +1,143 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// a base layer with the host's binaries as volumes, overlayfs doesn't show mounts
+// under its lower dirs so the testRootfs bind mounts wouldn't be visible
+func testBaseLayer(t *testing.T, dir string) []lnxns.Volume {
+	var volumes []lnxns.Volume
+	for _, name := range []string{"bin", "lib", "lib32", "lib64", "sbin", "usr"} {
+		host := path.Join("/", name)
+		fi, err := os.Lstat(host)
+		if err != nil {
+			continue
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			dest, _ := os.Readlink(host)
+			os.Symlink(dest, path.Join(dir, name))
+		} else {
+			volumes = append(volumes, lnxns.Volume{Source: host, Destination: host, ReadOnly: true})
+		}
+	}
+
+	for _, name := range []string{"dev", "etc", "proc", "sys", "tmp"} {
+		os.Mkdir(path.Join(dir, name), 0755)
+	}
+	ioutil.WriteFile(path.Join(dir, "etc", "base"), []byte("base\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "etc", "shadowed"), []byte("base\n"), 0644)
+
+	return volumes
+}
+
+func TestLaunchOverlay(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("launching containers requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "lnxns-overlay-")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	base, top := path.Join(dir, "base"), path.Join(dir, "top")
+	os.MkdirAll(path.Join(top, "etc"), 0755)
+	os.Mkdir(base, 0755)
+	volumes := testBaseLayer(t, base)
+	ioutil.WriteFile(path.Join(top, "etc", "shadowed"), []byte("top\n"), 0644)
+
+	run := func(ov *lnxns.Overlay, script string) string {
+		var out bytes.Buffer
+		c := launchOrSkip(t, &lnxns.Config{
+			Path:    "sh",
+			Args:    []string{"sh", "-c", script},
+			Root:    path.Join(dir, "root"),
+			Overlay: ov,
+			Volumes: volumes,
+			Stdout:  &out,
+			Stderr:  &out,
+		})
+		res, err := c.Wait()
+		if err != nil || !res.Success() {
+			t.Fatalf("the command failed: %v %v, Output: '%s'", res, err, out.String())
+		}
+		return out.String()
+	}
+
+	// the first lower layer is on top
+	ov := lnxns.NewOverlay(path.Join(dir, "c1"), top, base)
+	got := run(ov, `cat /etc/base /etc/shadowed; echo new > /etc/new; rm /etc/base`)
+	if got != "base\ntop\n" {
+		t.Fatalf("the layers were not stacked, Got: '%s'", got)
+	}
+
+	// the changes are kept in the upper dir and the layers are untouched
+	if data, _ := ioutil.ReadFile(path.Join(ov.Upper, "etc", "new")); string(data) != "new\n" {
+		t.Fatalf("a new file did not end up in the upper dir, Got: '%s'", data)
+	}
+	var st syscall.Stat_t
+	if err = syscall.Stat(path.Join(ov.Upper, "etc", "base"), &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFCHR {
+		t.Fatalf("a deleted file did not leave a whiteout in the upper dir: %s %o", err, st.Mode)
+	}
+	if _, err = os.Stat(path.Join(base, "etc", "base")); err != nil {
+		t.Fatalf("the container changed a lower layer: %s", err)
+	}
+
+	// a discarded upper dir is gone after Wait, the next container starts clean
+	ov = lnxns.NewOverlay(path.Join(dir, "c2"), top, base)
+	ov.Discard = true
+	if got = run(ov, `cat /etc/base; test -e /etc/new || echo clean; touch /etc/x`); got != "base\nclean\n" {
+		t.Fatalf("a second container did not start from the layers, Got: '%s'", got)
+	}
+	if _, err = os.Stat(path.Join(dir, "c2")); !os.IsNotExist(err) {
+		t.Fatalf("the overlay's directory was not discarded: %s", err)
+	}
+
+	// a child left behind in the mount namespace still has the overlay mounted,
+	// so it isn't discarded under it
+	ov = lnxns.NewOverlay(path.Join(dir, "c3"), top, base)
+	ov.Discard = true
+	pidFile := path.Join(dir, "pid")
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:       "sh",
+		Args:       []string{"sh", "-c", "sleep 10 & echo $! > /tmp/pid"},
+		Root:       path.Join(dir, "root"),
+		Dev:        true,
+		Overlay:    ov,
+		Volumes:    append(volumes, lnxns.Volume{Source: dir, Destination: "/tmp"}),
+		Namespaces: &lnxns.NamespaceSpec{Mount: lnxns.NewNamespace()},
+	})
+	_, err = c.Wait()
+	data, _ := ioutil.ReadFile(pidFile)
+	if pid, perr := strconv.Atoi(strings.TrimSpace(string(data))); perr == nil {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+	if err == nil {
+		t.Fatalf("Wait should refuse to discard an overlay that is still in use")
+	}
+	if _, err = os.Stat(ov.Upper); err != nil {
+		t.Fatalf("the upper dir of an overlay in use was discarded: %s", err)
+	}
+
+	_, err = lnxns.Launch(&lnxns.Config{Path: "true", Root: path.Join(dir, "root"), Overlay: &lnxns.Overlay{Lower: []string{base}}})
+	if err == nil {
+		t.Fatalf("Launch with an overlay without an upper dir should fail.")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
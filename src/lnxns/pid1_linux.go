@@ -0,0 +1,84 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// the tiny init behind Config.Init, similar to tini. It starts the command in its
+// own process group, forwards every signal it gets to that group, reaps whatever
+// gets reparented to it and exits with the command's status once the command is
+// gone. It's needed because pid 1 gets no default signal handlers, so a command
+// running as pid 1 ignores SIGTERM, and because orphans of a pid namespace are
+// left for its pid 1 to reap.
+func runInit(program string, cfg *Config, errPipe *os.File) error {
+	// orphans come to us even when there's no new pid namespace and we aren't pid 1
+	syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_CHILD_SUBREAPER, 1, 0)
+
+	// before the command starts so an early SIGCHLD isn't lost
+	sigs := make(chan os.Signal, 64)
+	signal.Notify(sigs)
+
+	// an interactive command needs to be the terminal's foreground process group
+	// or it gets stopped by SIGTTIN as soon as it reads
+	sys := &syscall.SysProcAttr{Setpgid: true}
+	if isTerminal(0) {
+		sys.Foreground = true
+		sys.Ctty = 0
+	}
+
+	proc, err := os.StartProcess(program, cfg.Args, &os.ProcAttr{
+		Env:   cfg.Env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   sys,
+	})
+	if err != nil {
+		signal.Reset()
+		return fmt.Errorf("exec %s: %s", program, err)
+	}
+
+	// the command is running, which Launch takes as success
+	errPipe.Close()
+
+	for {
+		for {
+			var ws syscall.WaitStatus
+			pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+			if err != nil || pid <= 0 {
+				break
+			}
+			if pid == proc.Pid {
+				os.Exit(newResult(ws, nil).Status())
+			}
+		}
+
+		sig := (<-sigs).(syscall.Signal)
+		switch sig {
+		case syscall.SIGCHLD:
+			// reaped at the top of the loop
+		case syscall.SIGURG:
+			// used by the Go runtime to preempt goroutines, not meant for anyone else
+		default:
+			if syscall.Kill(-proc.Pid, sig) == syscall.ESRCH {
+				// the command moved to another process group
+				syscall.Kill(proc.Pid, sig)
+			}
+		}
+	}
+}
+
+// true when fd is a terminal
+func isTerminal(fd int) bool {
+	var termios syscall.Termios
+	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return err == 0
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,88 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// zombie processes whose parent is pid
+func zombies(pid int) (found []int) {
+	pids, _ := lnxns.ListPids()
+	for _, p := range pids {
+		proc, err := lnxns.NewProcess(p)
+		if err != nil {
+			continue
+		}
+		status, err := proc.Status()
+		if err == nil && status["PPid"] == strconv.Itoa(pid) && strings.HasPrefix(status["State"], "Z") {
+			found = append(found, p)
+		}
+	}
+	return
+}
+
+// the shell-style status of a container
+func waitStatus(t *testing.T, c *lnxns.Container) int {
+	res, err := c.Wait()
+	if err != nil {
+		t.Errorf("Wait returned an error! '%s'", err)
+		return -1
+	}
+	return res.Status()
+}
+
+func TestInit(t *testing.T) {
+	c := launchOrSkip(t, &lnxns.Config{
+		Path: "sh",
+		Args: []string{"sh", "-c", "exit 3"},
+		Init: true,
+	})
+	if code := waitStatus(t, c); code != 3 {
+		t.Fatalf("init did not exit with the command's status, Got: %d", code)
+	}
+
+	// sleep as pid 1 would ignore SIGTERM
+	c = launchOrSkip(t, &lnxns.Config{
+		Path: "sleep",
+		Args: []string{"sleep", "10"},
+		Init: true,
+	})
+	time.Sleep(100 * time.Millisecond)
+	c.Signal(syscall.SIGTERM)
+
+	done := make(chan int, 1)
+	go func() { done <- waitStatus(t, c) }()
+	select {
+	case code := <-done:
+		if code != 128+int(syscall.SIGTERM) {
+			t.Fatalf("init did not exit with 128+SIGTERM, Got: %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		c.Signal(syscall.SIGKILL)
+		t.Fatalf("init did not forward SIGTERM")
+	}
+
+	// the backgrounded sleep is orphaned and has to be reaped by init
+	c = launchOrSkip(t, &lnxns.Config{
+		Path: "sh",
+		Args: []string{"sh", "-c", "sh -c 'sleep 0.1 &'; sleep 2"},
+		Init: true,
+	})
+	defer c.Wait()
+	defer c.Signal(syscall.SIGKILL)
+
+	time.Sleep(time.Second)
+	if z := zombies(c.Pid); len(z) > 0 {
+		t.Fatalf("init left zombies behind: %v", z)
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,151 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Pidfd is a handle on a process that stays tied to it after it exits, so unlike a
+// plain pid it can't end up pointing at an unrelated process once the pid is reused.
+// Needs Linux 5.3 or later, see pidfd_open(2).
+type Pidfd struct {
+	Pid int
+	fd  int
+	mtx sync.Mutex
+}
+
+var errPidfdClosed = errors.New("pidfd is closed")
+
+// open a handle on a running process. To be sure it's the process you mean, open it
+// while the pid can't be reused yet, e.g. on your own child before you wait for it.
+func OpenPidfd(pid int) (*Pidfd, error) {
+	fd, _, errno := syscall.RawSyscall(SYS_PIDFD_OPEN, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("pidfd_open %d: %w", pid, errno)
+	}
+
+	// pidfd_open(2) always sets close-on-exec
+	return &Pidfd{Pid: pid, fd: int(fd)}, nil
+}
+
+// send a signal to the process, fails with ESRCH once it has exited
+func (p *Pidfd) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %s", sig)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.fd < 0 {
+		return errPidfdClosed
+	}
+	_, _, errno := syscall.RawSyscall6(SYS_PIDFD_SEND_SIGNAL, uintptr(p.fd), uintptr(s), 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// true until the process exits, a zombie that hasn't been reaped yet counts as gone
+func (p *Pidfd) Alive() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.fd < 0 {
+		return false
+	}
+
+	// the pidfd becomes readable when the process exits
+	ready, err := pollIn(p.fd, &syscall.Timespec{})
+	return err == nil && !ready
+}
+
+// block until the process exits or ctx is done, in which case ctx.Err() is returned.
+// This doesn't reap the process, that's still up to its parent.
+func (p *Pidfd) Wait(ctx context.Context) error {
+	p.mtx.Lock()
+	if p.fd < 0 {
+		p.mtx.Unlock()
+		return errPidfdClosed
+	}
+	// a copy of our own, so a Close while we wait can't get the fd number reused
+	// for something else under us
+	fd, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(p.fd), syscall.F_DUPFD_CLOEXEC, 0)
+	p.mtx.Unlock()
+	if errno != 0 {
+		return errno
+	}
+	defer syscall.Close(int(fd))
+
+	ep, err := newEpoller()
+	if err != nil {
+		return err
+	}
+	defer ep.close()
+
+	if err = ep.add(int(fd), syscall.EPOLLIN); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ep.wake()
+		case <-done:
+		}
+	}()
+
+	for {
+		ready, woken, err := ep.wait(-1)
+		if err != nil {
+			return err
+		} else if len(ready) > 0 {
+			return nil
+		} else if woken {
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *Pidfd) Close() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.fd < 0 {
+		return nil
+	}
+	err := syscall.Close(p.fd)
+	p.fd = -1
+	return err
+}
+
+// ppoll(2) a single fd for POLLIN, timeout nil blocks forever
+func pollIn(fd int, timeout *syscall.Timespec) (bool, error) {
+	pfd := struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{fd: int32(fd), events: 0x1} // POLLIN
+
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1,
+		uintptr(unsafe.Pointer(timeout)), 0, 0, 0)
+	if errno != 0 {
+		return false, errno
+	}
+	return n > 0, nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
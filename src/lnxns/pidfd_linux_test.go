@@ -0,0 +1,92 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPidfd(t *testing.T) {
+	child := exec.Command("sleep", "10")
+	if err := child.Start(); err != nil {
+		t.Fatalf("could not start a child process: %s", err)
+	}
+	defer child.Wait()
+
+	p, err := lnxns.OpenPidfd(child.Process.Pid)
+	if errors.Is(err, syscall.ENOSYS) {
+		t.Skip("pidfd_open is not supported by this kernel")
+	} else if err != nil {
+		t.Fatalf("OpenPidfd failed: %s", err)
+	}
+	defer p.Close()
+
+	if !p.Alive() {
+		t.Fatalf("Alive returned false for a running process")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = p.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait should have timed out, Got: '%v'", err)
+	}
+
+	if err = p.Signal(syscall.SIGKILL); err != nil {
+		t.Fatalf("Signal failed: %s", err)
+	}
+	if err = p.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait failed: %s", err)
+	}
+	if p.Alive() {
+		t.Fatalf("Alive returned true for a dead process")
+	}
+
+	// once reaped, the pid may belong to someone else but the pidfd doesn't follow it
+	child.Wait()
+	if err = p.Signal(syscall.SIGKILL); err != syscall.ESRCH {
+		t.Fatalf("Signal to a reaped process should fail with ESRCH, Got: '%v'", err)
+	}
+}
+
+func TestContainerWaitContext(t *testing.T) {
+	c := launchOrSkip(t, &lnxns.Config{Path: "sleep", Args: []string{"sleep", "10"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitContext should have timed out, Got: '%v'", err)
+	}
+	if !c.Alive() {
+		t.Fatalf("the container should still be alive")
+	}
+
+	c.Signal(syscall.SIGKILL)
+	res, err := c.WaitContext(context.Background())
+	if err != nil || res.Signal != syscall.SIGKILL {
+		t.Fatalf("WaitContext failed, Got: %v, '%v'", res, err)
+	}
+	if c.Alive() {
+		t.Fatalf("the container should be gone")
+	}
+	if err = c.Signal(syscall.SIGKILL); err == nil {
+		t.Fatalf("Signal to a reaped container should fail")
+	}
+
+	// the command is only reaped once, later waits get the same result
+	if again, err := c.Wait(); err != nil || again != res {
+		t.Fatalf("a second Wait should return the first result, Got: %v, '%v'", again, err)
+	}
+	if again, err := c.WaitContext(context.Background()); err != nil || again != res {
+		t.Fatalf("WaitContext after Wait should return the first result, Got: %v, '%v'", again, err)
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,122 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+)
+
+// where ip-netns(8) keeps named network namespaces
+var NetnsDir = "/run/netns"
+
+// keep a namespace of pid alive by bind-mounting /proc/<pid>/ns/<nstype> onto target,
+// which is created if needed. The namespace then outlives pid and can be joined with
+// JoinNamespace(target) until UnpinNamespace(target) is called.
+// e.g. PinNamespace(container.Pid, "net", "/run/netns/blue")
+func PinNamespace(pid int, nstype string, target string) error {
+	if _, ok := nsFlags[nstype]; !ok {
+		return fmt.Errorf("unknown namespace type '%s'", nstype)
+	}
+
+	src := path.Join(ProcFs().Path(), strconv.Itoa(pid), "ns", nstype)
+	return bindNsFile(src, target)
+}
+
+// release a namespace pinned with PinNamespace and remove the file it was pinned on.
+// The namespace itself goes away once nothing else is using it.
+func UnpinNamespace(target string) error {
+	if err := syscall.Unmount(target, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+		return fmt.Errorf("could not unmount %s: %s", target, err)
+	}
+	return os.Remove(target)
+}
+
+// the path of a named network namespace, e.g. NetnsPath("blue") is /run/netns/blue
+func NetnsPath(name string) string {
+	return path.Join(NetnsDir, name)
+}
+
+// create an empty network namespace that lives in NetnsDir, same as `ip netns add`.
+// Returns the path to pass to JoinNamespace.
+func NewNamedNetns(name string) (string, error) {
+	if err := makeNetnsDir(); err != nil {
+		return "", err
+	}
+
+	target := NetnsPath(name)
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("network namespace '%s' already exists", name)
+	}
+
+	errc := make(chan error)
+	throwawayThread(func() {
+		if err := syscall.Unshare(CLONE_NEWNET); err != nil {
+			errc <- fmt.Errorf("unshare CLONE_NEWNET: %s", err)
+			return
+		}
+
+		src := path.Join(ProcFs().Path(), "self", "task", strconv.Itoa(syscall.Gettid()), "ns", "net")
+		errc <- bindNsFile(src, target)
+	})
+
+	if err := <-errc; err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// remove a namespace created with NewNamedNetns, same as `ip netns delete`
+func DeleteNamedNetns(name string) error {
+	return UnpinNamespace(NetnsPath(name))
+}
+
+// bind mount a namespace file onto target, creating it first
+func bindNsFile(src string, target string) error {
+	f, err := os.OpenFile(target, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	created := err == nil
+	if created {
+		f.Close()
+	}
+
+	if err = syscall.Mount(src, target, "none", syscall.MS_BIND, ""); err != nil {
+		if created {
+			os.Remove(target)
+		}
+		return fmt.Errorf("could not bind %s to %s: %s", src, target, err)
+	}
+
+	return nil
+}
+
+// NetnsDir has to be a shared mount so namespaces pinned there show up in every
+// mount namespace, like ip-netns(8) does it
+func makeNetnsDir() error {
+	if err := os.MkdirAll(NetnsDir, 0755); err != nil {
+		return err
+	}
+
+	err := syscall.Mount("", NetnsDir, "none", syscall.MS_SHARED|syscall.MS_REC, "")
+	if err == syscall.EINVAL {
+		// not a mount point yet
+		if err = syscall.Mount(NetnsDir, NetnsDir, "none", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("could not bind %s to itself: %s", NetnsDir, err)
+		}
+		err = syscall.Mount("", NetnsDir, "none", syscall.MS_SHARED|syscall.MS_REC, "")
+	}
+	if err != nil {
+		return fmt.Errorf("could not make %s shared: %s", NetnsDir, err)
+	}
+
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
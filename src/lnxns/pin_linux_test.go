@@ -0,0 +1,100 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestPinNamespace(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("pinning namespaces requires root")
+	}
+
+	tmpPath, err := ioutil.TempDir("", "lnxns-pin-")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpPath)
+
+	oldDir := lnxns.NetnsDir
+	lnxns.NetnsDir = path.Join(tmpPath, "netns")
+	defer func() { lnxns.NetnsDir = oldDir }()
+	defer syscall.Unmount(lnxns.NetnsDir, syscall.MNT_DETACH)
+
+	p, err := lnxns.NewNamedNetns("lnxns-test")
+	if err != nil {
+		t.Skipf("NewNamedNetns failed, namespaces are probably not available: %s", err)
+	}
+	if p != lnxns.NetnsPath("lnxns-test") {
+		t.Fatalf("NewNamedNetns returned the wrong path: %s", p)
+	}
+	if _, err = lnxns.NewNamedNetns("lnxns-test"); err == nil {
+		t.Fatalf("NewNamedNetns should fail for an existing name.")
+	}
+
+	// two commands joining the named namespace see the same one, which isn't ours
+	netns := func(ns lnxns.Namespace) string {
+		var out bytes.Buffer
+		c := launchOrSkip(t, &lnxns.Config{
+			Path:       "readlink",
+			Args:       []string{"readlink", "/proc/self/ns/net"},
+			Namespaces: &lnxns.NamespaceSpec{Net: ns},
+			Stdout:     &out,
+		})
+		c.Wait()
+		return strings.TrimSpace(out.String())
+	}
+
+	first := netns(lnxns.JoinNamespace(p))
+	if first == "" || first != netns(lnxns.JoinNamespace(p)) {
+		t.Fatalf("commands joining %s ended up in different namespaces", p)
+	}
+	if ours, _ := os.Readlink("/proc/self/ns/net"); ours == first {
+		t.Fatalf("NewNamedNetns did not create a new namespace")
+	}
+
+	if err = lnxns.DeleteNamedNetns("lnxns-test"); err != nil {
+		t.Fatalf("DeleteNamedNetns failed: %s", err)
+	}
+	if _, err = os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("DeleteNamedNetns left %s behind", p)
+	}
+
+	// pin the namespace of a container that exits right away
+	var out bytes.Buffer
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:       "sleep",
+		Args:       []string{"sleep", "10"},
+		Namespaces: &lnxns.NamespaceSpec{Net: lnxns.NewNamespace()},
+		Stdout:     &out,
+	})
+	pinned := path.Join(tmpPath, "pinned")
+	err = lnxns.PinNamespace(c.Pid, "net", pinned)
+	want, _ := os.Readlink(path.Join("/proc", itoa(uint64(c.Pid)), "ns", "net"))
+	c.Signal(os.Kill)
+	c.Wait()
+	if err != nil {
+		t.Fatalf("PinNamespace failed: %s", err)
+	}
+	defer lnxns.UnpinNamespace(pinned)
+
+	if got := netns(lnxns.JoinNamespace(pinned)); got != want {
+		t.Fatalf("the pinned namespace did not outlive its process, want %s, Got: %s", want, got)
+	}
+
+	if err = lnxns.PinNamespace(c.Pid, "bogus", pinned); err == nil {
+		t.Fatalf("PinNamespace should reject unknown namespace types.")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,299 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Process is for inspecting a running process through /proc/<pid>.
+type Process struct {
+	Pid int
+	vfs *Vfs
+}
+
+// one line of /proc/<pid>/cgroup, e.g. "4:memory:/tobert"
+// On the unified (v2) hierarchy HierarchyID is 0 and Controllers is empty.
+type CgroupMembership struct {
+	HierarchyID int
+	Controllers []string
+	Path        string
+}
+
+// one line of /proc/<pid>/limits, unlimited values are -1
+type Limit struct {
+	Soft  int64
+	Hard  int64
+	Units string
+}
+
+// capability bitmasks from /proc/<pid>/status, see capabilities(7)
+type Capabilities struct {
+	Inheritable uint64
+	Permitted   uint64
+	Effective   uint64
+	Bounding    uint64
+	Ambient     uint64
+}
+
+// create a Process handle for pid, returns an error if the process does not exist
+// p, err := NewProcess(os.Getpid())
+func NewProcess(pid int) (*Process, error) {
+	v, err := NewVfs(path.Join(ProcFs().Path(), strconv.Itoa(pid)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Process{Pid: pid, vfs: v}, nil
+}
+
+// list the pids of every process visible in /proc
+func ListPids() (pids []int, err error) {
+	entries, err := ioutil.ReadDir(ProcFs().Path())
+	if err != nil {
+		return
+	}
+
+	for _, fi := range entries {
+		if pid, perr := strconv.Atoi(fi.Name()); perr == nil {
+			pids = append(pids, pid)
+		}
+	}
+
+	return
+}
+
+// parsed /proc/<pid>/status
+// e.g. p.Status()["State"] = "S (sleeping)"
+func (p *Process) Status() (map[string]string, error) {
+	return p.vfs.GetColonMap("status")
+}
+
+// the argument list from /proc/<pid>/cmdline, empty for kernel threads and zombies
+func (p *Process) Cmdline() ([]string, error) {
+	return p.nulList("cmdline")
+}
+
+// the initial environment from /proc/<pid>/environ as key=value strings
+func (p *Process) Environ() ([]string, error) {
+	return p.nulList("environ")
+}
+
+// map of namespace name to the inode number that identifies it, two processes
+// share a namespace when the numbers match
+// e.g. p.Namespaces()["net"] = 4026531992
+func (p *Process) Namespaces() (map[string]uint64, error) {
+	nsDir := path.Join(p.vfs.Path(), "ns")
+	entries, err := ioutil.ReadDir(nsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make(map[string]uint64)
+	for _, fi := range entries {
+		link, err := os.Readlink(path.Join(nsDir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		// e.g. "net:[4026531992]"
+		start := strings.Index(link, ":[")
+		if start < 0 || !strings.HasSuffix(link, "]") {
+			return nil, fmt.Errorf("unexpected namespace link '%s'", link)
+		}
+		inode, err := strconv.ParseUint(link[start+2:len(link)-1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected namespace link '%s': %s", link, err)
+		}
+		namespaces[fi.Name()] = inode
+	}
+
+	return namespaces, nil
+}
+
+// parsed /proc/<pid>/cgroup
+func (p *Process) Cgroups() (list []CgroupMembership, err error) {
+	var perr error
+
+	parser := func(line string) {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			perr = fmt.Errorf("unexpected line in cgroup file: '%s'", line)
+			return
+		}
+
+		id, aerr := strconv.Atoi(parts[0])
+		if aerr != nil {
+			perr = aerr
+			return
+		}
+
+		cm := CgroupMembership{HierarchyID: id, Path: parts[2]}
+		if parts[1] != "" {
+			cm.Controllers = strings.Split(parts[1], ",")
+		}
+		list = append(list, cm)
+	}
+
+	if err = p.vfs.slurpLines("cgroup", parser); err == nil {
+		err = perr
+	}
+	return
+}
+
+// pids of the direct children of every thread in the process, requires a kernel
+// built with CONFIG_PROC_CHILDREN
+func (p *Process) Children() (children []int, err error) {
+	threads, err := p.Threads()
+	if err != nil {
+		return
+	}
+
+	for _, tid := range threads {
+		var kids []byte
+		kids, err = ioutil.ReadFile(path.Join(p.vfs.Path(), "task", strconv.Itoa(tid), "children"))
+		if os.IsNotExist(err) {
+			// the thread exited between listing and reading
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, kid := range strings.Fields(string(kids)) {
+			pid, aerr := strconv.Atoi(kid)
+			if aerr != nil {
+				return nil, aerr
+			}
+			children = append(children, pid)
+		}
+	}
+
+	return children, nil
+}
+
+// thread ids from /proc/<pid>/task, the first thread's id is the pid
+func (p *Process) Threads() (tids []int, err error) {
+	entries, err := ioutil.ReadDir(path.Join(p.vfs.Path(), "task"))
+	if err != nil {
+		return
+	}
+
+	for _, fi := range entries {
+		tid, aerr := strconv.Atoi(fi.Name())
+		if aerr != nil {
+			return nil, aerr
+		}
+		tids = append(tids, tid)
+	}
+
+	return
+}
+
+// parsed /proc/<pid>/limits keyed by the limit's name
+// e.g. p.Limits()["Max open files"] = Limit{Soft: 1024, Hard: 4096, Units: "files"}
+func (p *Process) Limits() (map[string]Limit, error) {
+	var perr error
+	limits := make(map[string]Limit)
+
+	// columns are aligned for humans, so parse from the right:
+	// Max cpu time              unlimited            unlimited            seconds
+	// Max nice priority         0                    0
+	parser := func(parts []string) {
+		if parts[0] == "Limit" || len(parts) < 3 {
+			return
+		}
+
+		var l Limit
+		if _, err := parseLimit(parts[len(parts)-1]); err != nil {
+			l.Units = parts[len(parts)-1]
+			parts = parts[:len(parts)-1]
+		}
+		if len(parts) < 3 {
+			perr = fmt.Errorf("unexpected line in limits file: %v", parts)
+			return
+		}
+
+		var err error
+		if l.Hard, err = parseLimit(parts[len(parts)-1]); err != nil {
+			perr = err
+		}
+		if l.Soft, err = parseLimit(parts[len(parts)-2]); err != nil {
+			perr = err
+		}
+
+		limits[strings.Join(parts[:len(parts)-2], " ")] = l
+	}
+
+	err := p.vfs.slurp("limits", parser)
+	if err == nil {
+		err = perr
+	}
+	return limits, err
+}
+
+// capability sets from /proc/<pid>/status
+func (p *Process) Capabilities() (*Capabilities, error) {
+	status, err := p.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var caps Capabilities
+	fields := map[string]*uint64{
+		"CapInh": &caps.Inheritable,
+		"CapPrm": &caps.Permitted,
+		"CapEff": &caps.Effective,
+		"CapBnd": &caps.Bounding,
+		"CapAmb": &caps.Ambient,
+	}
+
+	for key, dest := range fields {
+		value, ok := status[key]
+		if !ok {
+			// CapAmb only exists on Linux >= 4.3
+			continue
+		}
+		if *dest, err = strconv.ParseUint(value, 16, 64); err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err)
+		}
+	}
+
+	return &caps, nil
+}
+
+// read a NUL-separated file like cmdline or environ
+func (p *Process) nulList(name string) (list []string, err error) {
+	data, err := ioutil.ReadFile(path.Join(p.vfs.Path(), name))
+	if err != nil {
+		return
+	}
+
+	// every item ends in a NUL, empty items in between are real, e.g. an argument ""
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return
+	}
+	for _, item := range bytes.Split(data, []byte{0}) {
+		list = append(list, string(item))
+	}
+
+	return
+}
+
+// parse a limit value, "unlimited" is -1
+func parseLimit(value string) (int64, error) {
+	if value == "unlimited" {
+		return -1, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
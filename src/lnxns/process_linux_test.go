@@ -0,0 +1,129 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProcess(t *testing.T) {
+	_, err := lnxns.NewProcess(-1)
+	if err == nil {
+		t.Fatalf("NewProcess returned a nil error where a real error was expected.")
+	}
+
+	os.Setenv("LNXNS_TEST_ENV", "ok")
+	child := exec.Command("/bin/sleep", "10")
+	if err = child.Start(); err != nil {
+		t.Fatalf("could not start a child process: %s", err)
+	}
+	defer func() {
+		child.Process.Kill()
+		child.Wait()
+	}()
+
+	proc, err := lnxns.NewProcess(child.Process.Pid)
+	if err != nil {
+		t.Fatalf("NewProcess returned an error! '%s'", err)
+	}
+
+	// give the child a moment to get through exec, the name changes before the cmdline
+	status, err := proc.Status()
+	cmdline, _ := proc.Cmdline()
+	for i := 0; i < 100 && err == nil && (status["Name"] != "sleep" || len(cmdline) != 2); i++ {
+		time.Sleep(10 * time.Millisecond)
+		status, err = proc.Status()
+		cmdline, _ = proc.Cmdline()
+	}
+	if err != nil || status["Name"] != "sleep" {
+		t.Fatalf("Status failed, Got: '%v', '%v'", status, err)
+	}
+
+	cmdline, err = proc.Cmdline()
+	if err != nil || len(cmdline) != 2 || cmdline[1] != "10" {
+		t.Fatalf("Cmdline failed, Got: '%v', '%v'", cmdline, err)
+	}
+
+	environ, err := proc.Environ()
+	found := false
+	for _, kv := range environ {
+		found = found || kv == "LNXNS_TEST_ENV=ok"
+	}
+	if err != nil || !found {
+		t.Fatalf("Environ failed, Got: '%v', '%v'", environ, err)
+	}
+
+	namespaces, err := proc.Namespaces()
+	if err != nil || namespaces["net"] == 0 || namespaces["mnt"] == 0 {
+		t.Fatalf("Namespaces failed, Got: '%v', '%v'", namespaces, err)
+	}
+
+	cgroups, err := proc.Cgroups()
+	if err != nil || len(cgroups) == 0 {
+		t.Fatalf("Cgroups failed, Got: '%v', '%v'", cgroups, err)
+	}
+
+	threads, err := proc.Threads()
+	if err != nil || len(threads) != 1 || threads[0] != child.Process.Pid {
+		t.Fatalf("Threads failed, Got: '%v', '%v'", threads, err)
+	}
+
+	limits, err := proc.Limits()
+	if err != nil || limits["Max open files"].Units != "files" || limits["Max nice priority"].Units != "" {
+		t.Fatalf("Limits failed, Got: '%v', '%v'", limits, err)
+	}
+
+	if _, err = proc.Capabilities(); err != nil {
+		t.Fatalf("Capabilities returned an error! '%s'", err)
+	}
+
+	// an empty list is fine on kernels without CONFIG_PROC_CHILDREN
+	self, _ := lnxns.NewProcess(os.Getpid())
+	children, err := self.Children()
+	if err != nil {
+		t.Fatalf("Children returned an error! '%s'", err)
+	}
+	found = len(children) == 0
+	for _, pid := range children {
+		found = found || pid == child.Process.Pid
+	}
+	if !found {
+		t.Fatalf("Children failed, Got: '%v'", children)
+	}
+}
+
+func TestProcessCmdlineEmptyArg(t *testing.T) {
+	// wait for exec like TestProcess, the trailing : keeps sh from exec'ing sleep over its own cmdline
+	args := []string{"/bin/sh", "-c", "sleep 10; :", "", "b"}
+	child := exec.Command(args[0], args[1:]...)
+	if err := child.Start(); err != nil {
+		t.Fatalf("could not start a child process: %s", err)
+	}
+	defer func() {
+		child.Process.Kill()
+		child.Wait()
+	}()
+
+	proc, err := lnxns.NewProcess(child.Process.Pid)
+	if err != nil {
+		t.Fatalf("NewProcess returned an error! '%s'", err)
+	}
+
+	cmdline, err := proc.Cmdline()
+	for i := 0; i < 100 && err == nil && !reflect.DeepEqual(cmdline, args); i++ {
+		time.Sleep(10 * time.Millisecond)
+		cmdline, err = proc.Cmdline()
+	}
+	if err != nil || !reflect.DeepEqual(cmdline, args) {
+		t.Fatalf("Cmdline dropped or mangled an argument, Expected: %q, Got: %q, '%v'", args, cmdline, err)
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,174 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// paths hidden from containers unless Config.MaskedPaths says otherwise, mostly
+// the same as docker's. Files get /dev/null bound over them, directories an empty tmpfs.
+var DefaultMaskedPaths = []string{
+	"/proc/acpi",
+	"/proc/asound",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// paths made read-only in containers unless Config.ReadonlyPaths says otherwise
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// flags a bind mount has to keep when it is remounted, the kernel refuses to
+// clear the ones that are locked in a user namespace. statfs(2)'s ST_* flags have
+// the same values.
+const remountKeepFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+	syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+// mount a fresh proc on root/proc, which shows the processes of the container's
+// pid namespace. Nothing is mounted when the container has no /proc directory.
+func mountProcfs(root string) error {
+	target, err := resolveInRoot(root, "proc")
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(target); err != nil || !st.IsDir() {
+		return nil
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err = syscall.Mount("proc", target, "proc", flags, ""); err != nil {
+		return fmt.Errorf("could not mount proc on %s: %s", target, err)
+	}
+
+	return nil
+}
+
+// mount a read-only sysfs on root/sys. Only the owner of the network namespace may
+// mount sysfs, so in a user namespace without a new network namespace the host's
+// /sys and everything mounted under it, e.g. /sys/fs/cgroup, is bound read-only instead.
+func mountSysfs(root string) error {
+	target, err := resolveInRoot(root, "sys")
+	if err != nil {
+		return err
+	}
+	if st, err := os.Stat(target); err != nil || !st.IsDir() {
+		return nil
+	}
+
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_RDONLY)
+	err = syscall.Mount("sysfs", target, "sysfs", flags, "")
+	if err == syscall.EPERM {
+		if err = syscall.Mount("/sys", target, "", syscall.MS_BIND|syscall.MS_REC, ""); err == nil {
+			return remountBindTree(target, syscall.MS_RDONLY)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not mount sysfs on %s: %s", target, err)
+	}
+
+	return nil
+}
+
+// hide each of paths under root, the ones that don't exist are skipped
+func maskPaths(root string, paths []string) error {
+	for _, p := range paths {
+		target, err := resolveInRoot(root, p)
+		if err != nil {
+			return err
+		}
+		st, err := os.Stat(target)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if st.IsDir() {
+			err = syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_RDONLY, "size=0")
+		} else {
+			err = syscall.Mount("/dev/null", target, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("could not mask %s: %s", p, err)
+		}
+	}
+
+	return nil
+}
+
+// make each of paths under root read-only, the ones that don't exist are skipped
+func readonlyPaths(root string, paths []string) error {
+	for _, p := range paths {
+		target, err := resolveInRoot(root, p)
+		if err != nil {
+			return err
+		}
+		if _, err = os.Stat(target); os.IsNotExist(err) {
+			continue
+		}
+
+		if err = syscall.Mount(target, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("could not bind %s to itself: %s", p, err)
+		}
+		if err = remountBind(target, syscall.MS_RDONLY); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// change the flags of a bind mount, keeping the ones it already has
+func remountBind(target string, flags uintptr) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(target, &st); err != nil {
+		return fmt.Errorf("statfs %s: %s", target, err)
+	}
+
+	flags |= uintptr(st.Flags)&remountKeepFlags | syscall.MS_BIND | syscall.MS_REMOUNT
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("could not remount %s: %s", target, err)
+	}
+
+	return nil
+}
+
+// remountBind target and every mount under it. A recursive bind copies the submounts
+// but a remount only changes the one mount it is given, and in a user namespace a
+// non-recursive bind of a tree with locked submounts is refused.
+func remountBindTree(target string, flags uintptr) error {
+	mounts, err := ProcFs().GetMountInfo("self/mountinfo")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mounts {
+		if m.Mountpoint == target || strings.HasPrefix(m.Mountpoint, target+"/") {
+			if err = remountBind(m.Mountpoint, flags); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
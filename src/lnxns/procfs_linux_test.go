@@ -0,0 +1,63 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"testing"
+)
+
+// run a shell script in a container on a test rootfs and return its output
+func runInRootfs(t *testing.T, cfg *lnxns.Config, script string) string {
+	root, cleanup := testRootfs(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+	cfg.Path = "sh"
+	cfg.Args = []string{"sh", "-c", script}
+	cfg.Root = root
+	cfg.Stdout = &out
+	cfg.Stderr = &out
+
+	c := launchOrSkip(t, cfg)
+	res, err := c.Wait()
+	if err != nil || !res.Success() {
+		t.Fatalf("the command failed: %v %v, Output: '%s'", res, err, out.String())
+	}
+
+	return out.String()
+}
+
+func TestLaunchProcSys(t *testing.T) {
+	script := `cut -d" " -f4 /proc/self/stat; stat -f -c %T /proc /sys; wc -c < /proc/timer_list; ls /sys/firmware | wc -l;` +
+		`test -w /proc/sys/kernel/hostname || echo ro; test -w /sys/kernel || echo ro`
+	got := runInRootfs(t, &lnxns.Config{}, script)
+
+	// the shell is pid 1 and sees its own pid namespace
+	expected := "1\nproc\nsysfs\n0\n0\nro\nro\n"
+	if got != expected {
+		t.Fatalf("/proc and /sys were not set up, Expected: '%s' Got: '%s'", expected, got)
+	}
+}
+
+func TestLaunchMaskedPaths(t *testing.T) {
+	cfg := lnxns.Config{
+		MaskedPaths:   []string{"/proc/version", "/does/not/exist"},
+		ReadonlyPaths: []string{},
+	}
+	got := runInRootfs(t, &cfg, `wc -c < /proc/version; test -w /proc/sys/kernel/hostname && echo rw`)
+
+	if got != "0\nrw\n" {
+		t.Fatalf("the configured paths were not used, Got: '%s'", got)
+	}
+
+	_, err := lnxns.Launch(&lnxns.Config{Path: "true", MaskedPaths: []string{"proc/kcore"}})
+	if err == nil {
+		t.Fatalf("Launch with a relative masked path should fail.")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,68 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Result is how a container's command ended, as returned by Container.Wait.
+type Result struct {
+	ExitCode int             // the exit code, -1 when killed by a signal
+	Signal   syscall.Signal  // the signal that killed the command, 0 when it exited
+	CoreDump bool            // the command dumped core
+	Rusage   *syscall.Rusage // resources used by the command and its reaped children
+}
+
+// convert a wait(2) status
+func newResult(ws syscall.WaitStatus, rusage *syscall.Rusage) *Result {
+	res := Result{
+		ExitCode: ws.ExitStatus(),
+		Rusage:   rusage,
+	}
+
+	if ws.Signaled() {
+		res.Signal = ws.Signal()
+		res.CoreDump = ws.CoreDump()
+	}
+
+	return &res
+}
+
+// the Result of a command started with os/exec, e.g. after cmd.Wait() the status
+// nsexec exits with is ProcessResult(cmd.ProcessState).Status()
+func ProcessResult(ps *os.ProcessState) *Result {
+	ws := ps.Sys().(syscall.WaitStatus)
+	rusage, _ := ps.SysUsage().(*syscall.Rusage)
+	return newResult(ws, rusage)
+}
+
+// the status a shell would report, the exit code or 128+signal
+// e.g. os.Exit(res.Status())
+func (res *Result) Status() int {
+	if res.Signal != 0 {
+		return 128 + int(res.Signal)
+	}
+	return res.ExitCode
+}
+
+// true if the command exited with 0
+func (res *Result) Success() bool {
+	return res.Signal == 0 && res.ExitCode == 0
+}
+
+// same wording as os.ProcessState, e.g. "exit status 1" or "signal: killed"
+func (res *Result) String() string {
+	if res.Signal == 0 {
+		return fmt.Sprintf("exit status %d", res.ExitCode)
+	} else if res.CoreDump {
+		return fmt.Sprintf("signal: %s (core dumped)", res.Signal)
+	}
+	return fmt.Sprintf("signal: %s", res.Signal)
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,80 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// turn the container's root directory into a mount point, which pivot_root(2)
+// requires. Recursive so mounts already under it come along.
+func bindRootfs(root string) error {
+	if err := syscall.Mount(root, root, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("could not bind %s to itself: %s", root, err)
+	}
+	return nil
+}
+
+// make root the container's / with pivot_root(2) and detach the old root, so
+// unlike chroot(2) there is no way back out to the host's filesystem. Must run in a
+// new mount namespace with private mounts, after bindRootfs. Falls back to chroot
+// when the current root is a ramfs or tmpfs (e.g. an initramfs), which can't be pivoted.
+func pivotRootfs(root string) error {
+	oldRoot, err := syscall.Open("/", syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open /: %s", err)
+	}
+	defer syscall.Close(oldRoot)
+
+	if err = syscall.Chdir(root); err != nil {
+		return fmt.Errorf("chdir %s: %s", root, err)
+	}
+
+	// pivoting onto "." stacks the old root on top of the new one, which saves
+	// making a directory for it inside the container
+	err = syscall.PivotRoot(".", ".")
+	if err == syscall.EINVAL && onRamfs() {
+		return chrootRootfs(".")
+	} else if err != nil {
+		return fmt.Errorf("pivot_root %s: %s", root, err)
+	}
+
+	if err = syscall.Fchdir(oldRoot); err != nil {
+		return fmt.Errorf("fchdir to the old root: %s", err)
+	}
+	// don't let the unmount propagate anywhere
+	if err = syscall.Mount("", ".", "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("could not make the old root a slave mount: %s", err)
+	}
+	if err = syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("could not detach the old root: %s", err)
+	}
+
+	return syscall.Chdir("/")
+}
+
+// whether / is the rootfs ramfs or tmpfs of an initramfs, any other EINVAL from
+// pivot_root(2) is a real error
+func onRamfs() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs("/", &st); err != nil {
+		return false
+	}
+	return uint32(st.Type) == RAMFS_MAGIC || uint32(st.Type) == TMPFS_MAGIC
+}
+
+// the fallback for when there is no new mount namespace or pivoting isn't possible
+func chrootRootfs(root string) error {
+	if err := syscall.Chdir(root); err != nil {
+		return fmt.Errorf("chdir %s: %s", root, err)
+	}
+	if err := syscall.Chroot("."); err != nil {
+		return fmt.Errorf("chroot %s: %s", root, err)
+	}
+	return syscall.Chdir("/")
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
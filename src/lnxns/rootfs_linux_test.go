@@ -0,0 +1,48 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPivotRoot(t *testing.T) {
+	root, cleanup := testRootfs(t)
+	defer cleanup()
+
+	c := launchOrSkip(t, &lnxns.Config{
+		Path: "sleep",
+		Args: []string{"sleep", "10"},
+		Root: root,
+	})
+	defer c.Wait()
+	defer c.Signal(os.Kill)
+
+	// Launch returns after the exec, so the container's mounts are final
+	proc, _ := lnxns.NewProcess(c.Pid)
+	mounts, err := proc.MountInfo()
+	if err != nil {
+		t.Fatalf("could not read the container's mounts: %s", err)
+	}
+
+	// the rootfs is the container's / and nothing of the host's tree is left
+	for _, m := range mounts {
+		if m.Mountpoint == "/" && !strings.HasSuffix(m.Root, path.Base(root)) {
+			t.Fatalf("the container's / is not the rootfs: %+v", m)
+		}
+		if strings.HasPrefix(m.Mountpoint, root) {
+			t.Fatalf("the container can still see the host's mounts: %+v", m)
+		}
+	}
+	if len(mounts) == 0 || mounts[0].Mountpoint != "/" {
+		t.Fatalf("the container's first mount should be /, Got: %+v", mounts)
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,231 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// namespace names as they appear in /proc/<pid>/ns, mapped to their clone flag
+var nsFlags = map[string]int{
+	"mnt":    CLONE_NEWNS,
+	"uts":    CLONE_NEWUTS,
+	"ipc":    CLONE_NEWIPC,
+	"user":   CLONE_NEWUSER,
+	"pid":    CLONE_NEWPID,
+	"net":    CLONE_NEWNET,
+	"cgroup": CLONE_NEWCGROUP,
+	"time":   CLONE_NEWTIME,
+}
+
+// run fn in a new goroutine locked to a thread that is thrown away afterwards, for
+// changes to a thread that must never go back to the scheduler. The main thread can't
+// be thrown away, the runtime wedges it instead of exiting it, and its namespaces are
+// the ones /proc/<pid>/ns shows for the whole process. When the goroutine lands on it,
+// it keeps the main thread busy while fn runs on another and then hands it back untouched.
+func throwawayThread(fn func()) {
+	go func() {
+		runtime.LockOSThread()
+		if syscall.Gettid() != os.Getpid() {
+			// never unlocked, the thread exits along with this goroutine
+			fn()
+			return
+		}
+
+		done := make(chan bool)
+		go func() {
+			runtime.LockOSThread()
+			fn()
+			done <- true
+		}()
+		<-done
+		runtime.UnlockOSThread()
+	}()
+}
+
+// call setns(2) on the current thread
+func setns(fd int, nstype int) error {
+	_, _, err := syscall.RawSyscall(SYS_SETNS, uintptr(fd), uintptr(nstype), 0)
+	if err != 0 {
+		return err
+	}
+	return nil
+}
+
+// a spec that joins every namespace of pid that differs from the caller's. User and
+// time namespaces are left out because a multithreaded process can't join them.
+// spec, err := JoinAllNamespaces(container.Pid)
+func JoinAllNamespaces(pid int) (*NamespaceSpec, error) {
+	self, err := NewProcess(os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+	ours, err := self.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := target.Namespaces()
+	if err != nil {
+		return nil, err
+	}
+
+	spec := NamespaceSpec{}
+	for _, e := range spec.entries() {
+		if e.name == "user" || e.name == "time" {
+			continue
+		}
+		if inode, ok := theirs[e.name]; ok && inode != ours[e.name] {
+			*e.ns = JoinNamespacePid(pid)
+		}
+	}
+
+	return &spec, nil
+}
+
+// call fn on a locked OS thread that has joined the namespaces in spec marked
+// NamespaceJoin, e.g. to poke at a container's network. Only the thread running fn
+// is affected; it is thrown away afterwards instead of going back to the scheduler.
+func RunInNamespaces(spec *NamespaceSpec, fn func() error) error {
+	if err := spec.validateJoinOnly(); err != nil {
+		return err
+	}
+
+	return spec.onThread(true, fn)
+}
+
+// start cmd in the namespaces in spec marked NamespaceJoin, like nsenter(1).
+// A cmd.Path without a slash is looked up inside the joined mount namespace.
+func StartInNamespaces(spec *NamespaceSpec, cmd *exec.Cmd) error {
+	if err := spec.validateJoinOnly(); err != nil {
+		return err
+	}
+
+	return spec.onThread(true, func() error {
+		if !strings.Contains(cmd.Path, "/") {
+			env := cmd.Env
+			if env == nil {
+				env = os.Environ()
+			}
+			p, err := lookPath(cmd.Path, env)
+			if err != nil {
+				return err
+			}
+			cmd.Path = p
+		}
+		return cmd.Start()
+	})
+}
+
+// RunInNamespaces and StartInNamespaces can only join, unlike Launch
+func (spec *NamespaceSpec) validateJoinOnly() error {
+	if err := spec.validate(); err != nil {
+		return err
+	}
+
+	for _, e := range spec.entries() {
+		if e.ns.Mode == NamespaceNew {
+			return fmt.Errorf("cannot create a new %s namespace here, only join", e.name)
+		}
+	}
+
+	return nil
+}
+
+// run fn on a fresh locked thread after joining every NamespaceJoin entry. With
+// enterRoot, a mount namespace joined by pid also takes that process's root and
+// working directory. The thread comes from throwawayThread and is never unlocked,
+// so the Go runtime exits it along with the goroutine.
+func (spec *NamespaceSpec) onThread(enterRoot bool, fn func() error) error {
+	var joins []*os.File
+	var flags []int
+	var root, cwd *os.File
+
+	defer func() {
+		for _, f := range append(joins, root, cwd) {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+
+	// open everything up front, /proc may look different after the mount namespace changes
+	for _, e := range spec.entries() {
+		if e.ns.Mode != NamespaceJoin {
+			continue
+		}
+
+		p, _ := e.ns.nsPath(e.name)
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		joins = append(joins, f)
+		flags = append(flags, nsFlags[e.name])
+	}
+
+	// setns(2) puts the thread at the root of the mount namespace, but the process we
+	// are joining may have chrooted, so take its root and working directory as well
+	if enterRoot && spec.Mount.Mode == NamespaceJoin && spec.Mount.Path == "" {
+		procDir := path.Join(ProcFs().Path(), strconv.Itoa(spec.Mount.Pid))
+		var err error
+		if root, err = os.Open(path.Join(procDir, "root")); err != nil {
+			return err
+		}
+		if cwd, err = os.Open(path.Join(procDir, "cwd")); err != nil {
+			return err
+		}
+	}
+
+	errc := make(chan error)
+	throwawayThread(func() {
+		// a thread can only change mount namespace once it has its own fs_struct
+		if spec.Mount.Mode == NamespaceJoin {
+			if err := syscall.Unshare(CLONE_FS); err != nil {
+				errc <- fmt.Errorf("unshare CLONE_FS: %s", err)
+				return
+			}
+		}
+
+		for i, f := range joins {
+			if err := setns(int(f.Fd()), flags[i]); err != nil {
+				errc <- fmt.Errorf("setns %s: %s", f.Name(), err)
+				return
+			}
+		}
+
+		if root != nil {
+			if err := syscall.Fchdir(int(root.Fd())); err != nil {
+				errc <- fmt.Errorf("fchdir %s: %s", root.Name(), err)
+				return
+			}
+			if err := syscall.Chroot("."); err != nil {
+				errc <- fmt.Errorf("chroot %s: %s", root.Name(), err)
+				return
+			}
+			if err := syscall.Fchdir(int(cwd.Fd())); err != nil {
+				errc <- fmt.Errorf("fchdir %s: %s", cwd.Name(), err)
+				return
+			}
+		}
+
+		errc <- fn()
+	})
+
+	return <-errc
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
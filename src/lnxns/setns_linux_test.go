@@ -0,0 +1,72 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestJoinNamespaces(t *testing.T) {
+	c := launchOrSkip(t, &lnxns.Config{
+		Path: "sh",
+		Args: []string{"sh", "-c", "hostname lnxns-setns-test && exec sleep 10"},
+	})
+	defer c.Wait()
+	defer c.Signal(os.Kill)
+
+	spec, err := lnxns.JoinAllNamespaces(c.Pid)
+	if err != nil {
+		t.Fatalf("JoinAllNamespaces failed: %s", err)
+	}
+	if spec.Uts.Mode != lnxns.NamespaceJoin || spec.Mount.Mode != lnxns.NamespaceJoin {
+		t.Fatalf("JoinAllNamespaces missed a namespace: %+v", spec)
+	}
+	if spec.Net.Mode != lnxns.NamespaceHost || spec.User.Mode != lnxns.NamespaceHost {
+		t.Fatalf("JoinAllNamespaces joined a namespace the container shares with us: %+v", spec)
+	}
+
+	// the container sets its hostname after it starts
+	var hostname string
+	for i := 0; i < 100 && hostname != "lnxns-setns-test"; i++ {
+		err = lnxns.RunInNamespaces(spec, func() (err error) {
+			hostname, err = os.Hostname()
+			return
+		})
+		if err != nil {
+			t.Fatalf("RunInNamespaces failed: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hostname != "lnxns-setns-test" {
+		t.Fatalf("RunInNamespaces did not join the uts namespace, Got: '%s'", hostname)
+	}
+
+	// the calling thread must not have moved
+	if ours, _ := os.Hostname(); ours == hostname {
+		t.Fatalf("RunInNamespaces changed the caller's namespaces")
+	}
+
+	var out bytes.Buffer
+	cmd := &exec.Cmd{Path: "hostname", Args: []string{"hostname"}, Stdout: &out}
+	if err = lnxns.StartInNamespaces(spec, cmd); err != nil {
+		t.Fatalf("StartInNamespaces failed: %s", err)
+	}
+	cmd.Wait()
+	if out.String() != "lnxns-setns-test\n" {
+		t.Fatalf("StartInNamespaces did not join the uts namespace, Got: '%s'", out.String())
+	}
+
+	err = lnxns.RunInNamespaces(&lnxns.NamespaceSpec{Net: lnxns.NewNamespace()}, func() error { return nil })
+	if err == nil {
+		t.Fatalf("RunInNamespaces should refuse to create namespaces.")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
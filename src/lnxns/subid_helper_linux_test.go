@@ -0,0 +1,65 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+// the newuidmap/newgidmap path can't be reached from outside the package when the
+// tests run as root, so it's tested from inside
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestNeedsIDMapHelper(t *testing.T) {
+	tests := []struct {
+		name      string
+		maps      []IDMap
+		euid, own int
+		expected  bool
+	}{
+		{"root writes anything", []IDMap{{0, 0, 1}, {1, 100000, 65536}}, 0, 0, false},
+		{"no map", nil, 1000, 1000, false},
+		{"only our own id", []IDMap{{0, 1000, 1}}, 1000, 1000, false},
+		{"our own gid", []IDMap{{0, 1001, 1}}, 1000, 1001, false},
+		{"someone else's id", []IDMap{{0, 1002, 1}}, 1000, 1000, true},
+		{"more than our id", []IDMap{{0, 1000, 2}}, 1000, 1000, true},
+		{"delegated ranges", []IDMap{{0, 1000, 1}, {1, 100000, 65536}}, 1000, 1000, true},
+	}
+
+	for _, test := range tests {
+		if got := needsIDMapHelper(test.maps, test.euid, test.own); got != test.expected {
+			t.Fatalf("%s: needsIDMapHelper should be %v", test.name, test.expected)
+		}
+	}
+}
+
+func TestRunIDMapHelper(t *testing.T) {
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-subid")
+	defer os.RemoveAll(tmpPath)
+
+	// stands in for newuidmap, which gets the pid and then container, host, size triples
+	args := path.Join(tmpPath, "args")
+	helper := path.Join(tmpPath, "newuidmap")
+	ioutil.WriteFile(helper, []byte("#!/bin/sh\necho \"$@\" > "+args+"\n"), 0755)
+
+	maps := []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}, {ContainerID: 1, HostID: 100000, Size: 65536}}
+	if err := runIDMapHelper(helper, 1234, maps); err != nil {
+		t.Fatalf("runIDMapHelper failed: %s", err)
+	}
+	if data, _ := ioutil.ReadFile(args); strings.TrimSpace(string(data)) != "1234 0 1000 1 1 100000 65536" {
+		t.Fatalf("runIDMapHelper passed the wrong arguments, Got: '%s'", data)
+	}
+
+	// the helper's complaint ends up in the error
+	ioutil.WriteFile(helper, []byte("#!/bin/sh\necho 'newuidmap: nope' >&2\nexit 1\n"), 0755)
+	if err := runIDMapHelper(helper, 1234, maps); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Fatalf("runIDMapHelper should fail with the helper's output, Got: '%v'", err)
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,165 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// where shadow-utils keeps subordinate id delegations, see subuid(5)
+var (
+	SubUIDFile = "/etc/subuid"
+	SubGIDFile = "/etc/subgid"
+)
+
+// SubIDRange is one line of /etc/subuid or /etc/subgid, e.g. "tobert:100000:65536".
+// Name may be a user name or a numeric id.
+type SubIDRange struct {
+	Name  string
+	Start int
+	Count int
+}
+
+// parse a subuid/subgid file, comments and blank lines are skipped
+func ParseSubIDs(file string) (ranges []SubIDRange, err error) {
+	vfs := Vfs{Mountpoint: path.Dir(file)}
+	var perr error
+
+	parser := func(line string) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			return
+		}
+
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 {
+			perr = fmt.Errorf("%s: bad line '%s'", file, line)
+			return
+		}
+
+		r := SubIDRange{Name: parts[0]}
+		var aerr error
+		if r.Start, aerr = strconv.Atoi(parts[1]); aerr != nil {
+			perr = fmt.Errorf("%s: bad start in '%s': %s", file, line, aerr)
+			return
+		}
+		if r.Count, aerr = strconv.Atoi(parts[2]); aerr != nil {
+			perr = fmt.Errorf("%s: bad count in '%s': %s", file, line, aerr)
+			return
+		}
+		ranges = append(ranges, r)
+	}
+
+	if err = vfs.slurpLines(path.Base(file), parser); err == nil {
+		err = perr
+	}
+	return
+}
+
+// the ranges delegated to a user, matched by name or by uid, for /etc/subgid too
+// e.g. LookupSubIDs(SubUIDFile, "tobert", 1000)
+func LookupSubIDs(file string, name string, id int) (ranges []SubIDRange, err error) {
+	all, err := ParseSubIDs(file)
+	if err != nil {
+		return
+	}
+
+	for _, r := range all {
+		if r.Name == name || r.Name == strconv.Itoa(id) {
+			ranges = append(ranges, r)
+		}
+	}
+
+	return
+}
+
+// id maps for an unprivileged container. Container root is always the caller. If
+// /etc/subuid and /etc/subgid delegate ranges to the caller, ids from 1 up are mapped
+// onto them and delegated is true, otherwise only the single id is mapped.
+func RootlessIDMaps() (uids []IDMap, gids []IDMap, delegated bool, err error) {
+	uids, gids = defaultIDMaps()
+
+	u, err := user.Current()
+	if err != nil {
+		return
+	}
+
+	// both files are keyed by login name or uid, /etc/subgid never by gid
+	subuids, uerr := LookupSubIDs(SubUIDFile, u.Username, os.Geteuid())
+	subgids, gerr := LookupSubIDs(SubGIDFile, u.Username, os.Geteuid())
+	if uerr != nil && !os.IsNotExist(uerr) {
+		return nil, nil, false, uerr
+	} else if gerr != nil && !os.IsNotExist(gerr) {
+		return nil, nil, false, gerr
+	} else if len(subuids) == 0 || len(subgids) == 0 {
+		return uids, gids, false, nil
+	}
+
+	next := 1
+	for _, r := range subuids {
+		uids = append(uids, IDMap{ContainerID: next, HostID: r.Start, Size: r.Count})
+		next += r.Count
+	}
+	next = 1
+	for _, r := range subgids {
+		gids = append(gids, IDMap{ContainerID: next, HostID: r.Start, Size: r.Count})
+		next += r.Count
+	}
+
+	return uids, gids, true, nil
+}
+
+// set up cfg to run without root: a new user namespace on top of the configured
+// (or default) namespaces, with the maps from RootlessIDMaps(). setgroups is denied
+// when there is no delegated range since the kernel won't allow a gid map otherwise.
+func (cfg *Config) SetRootless() (delegated bool, err error) {
+	uids, gids, delegated, err := RootlessIDMaps()
+	if err != nil {
+		return
+	}
+
+	if cfg.Namespaces == nil {
+		cfg.Namespaces = DefaultNamespaceSpec()
+	}
+	cfg.Namespaces.User = NewNamespace()
+	cfg.UidMappings = uids
+	cfg.GidMappings = gids
+	cfg.DenySetgroups = !delegated
+
+	return
+}
+
+// an unprivileged process can only write a map of its own id, anything else
+// has to go through the setuid newuidmap/newgidmap helpers. euid is the caller's
+// effective uid, own its uid or gid depending on the map.
+func needsIDMapHelper(maps []IDMap, euid, own int) bool {
+	if euid == 0 || len(maps) == 0 {
+		return false
+	}
+	return len(maps) != 1 || maps[0].HostID != own || maps[0].Size != 1
+}
+
+// run newuidmap or newgidmap for pid, e.g. newuidmap 1234 0 1000 1 1 100000 65536
+func runIDMapHelper(helper string, pid int, maps []IDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+
+	out, err := exec.Command(helper, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s: %s", helper, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,115 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSubIDs(t *testing.T) {
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-subid")
+	defer os.RemoveAll(tmpPath)
+
+	subuid := path.Join(tmpPath, "subuid")
+	ioutil.WriteFile(subuid, []byte("# comment\ntobert:100000:65536\n\n1001:165536:65536\ntobert:300000:10\n"), 0644)
+
+	all, err := lnxns.ParseSubIDs(subuid)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ParseSubIDs failed, Got: '%v', '%v'", all, err)
+	}
+
+	mine, err := lnxns.LookupSubIDs(subuid, "tobert", 1000)
+	if err != nil || len(mine) != 2 || mine[0].Start != 100000 || mine[1].Count != 10 {
+		t.Fatalf("LookupSubIDs by name failed, Got: '%v', '%v'", mine, err)
+	}
+
+	byID, err := lnxns.LookupSubIDs(subuid, "someone", 1001)
+	if err != nil || len(byID) != 1 || byID[0].Start != 165536 {
+		t.Fatalf("LookupSubIDs by id failed, Got: '%v', '%v'", byID, err)
+	}
+
+	ioutil.WriteFile(subuid, []byte("tobert:100000\n"), 0644)
+	if _, err = lnxns.ParseSubIDs(subuid); err == nil {
+		t.Fatalf("ParseSubIDs returned a nil error for a bad line.")
+	}
+
+	if _, err = lnxns.ParseSubIDs(path.Join(tmpPath, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("ParseSubIDs of a missing file should return a not-exist error, Got: '%v'", err)
+	}
+}
+
+func TestRootlessIDMaps(t *testing.T) {
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-subid")
+	defer os.RemoveAll(tmpPath)
+
+	u, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %s", err)
+	}
+	uid, gid := os.Geteuid(), os.Getegid()
+	self := []lnxns.IDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+	selfGid := []lnxns.IDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+
+	defer func(uidFile, gidFile string) {
+		lnxns.SubUIDFile, lnxns.SubGIDFile = uidFile, gidFile
+	}(lnxns.SubUIDFile, lnxns.SubGIDFile)
+	lnxns.SubUIDFile = path.Join(tmpPath, "subuid")
+	lnxns.SubGIDFile = path.Join(tmpPath, "subgid")
+
+	// "" means the file doesn't exist
+	tests := []struct {
+		name           string
+		subuid, subgid string
+		uids, gids     []lnxns.IDMap
+		delegated, err bool
+	}{
+		{"no files", "", "", self, selfGid, false, false},
+		{"someone else's ranges", "nobody-else:100000:65536\n", "nobody-else:100000:65536\n", self, selfGid, false, false},
+		{"only subuid", u.Username + ":100000:65536\n", "", self, selfGid, false, false},
+		{
+			"by name", u.Username + ":100000:65536\n", u.Username + ":200000:65536\n",
+			append(self, lnxns.IDMap{ContainerID: 1, HostID: 100000, Size: 65536}),
+			append(selfGid, lnxns.IDMap{ContainerID: 1, HostID: 200000, Size: 65536}),
+			true, false,
+		},
+		{
+			// the second range starts where the first one ends in the container
+			"by id, two ranges", strconv.Itoa(uid) + ":100000:10\n" + u.Username + ":300000:5\n",
+			strconv.Itoa(uid) + ":100000:10\n",
+			append(self, lnxns.IDMap{ContainerID: 1, HostID: 100000, Size: 10}, lnxns.IDMap{ContainerID: 11, HostID: 300000, Size: 5}),
+			append(selfGid, lnxns.IDMap{ContainerID: 1, HostID: 100000, Size: 10}),
+			true, false,
+		},
+		{"bad subuid", u.Username + ":100000\n", u.Username + ":100000:65536\n", nil, nil, false, true},
+	}
+
+	for _, test := range tests {
+		os.Remove(lnxns.SubUIDFile)
+		os.Remove(lnxns.SubGIDFile)
+		if test.subuid != "" {
+			ioutil.WriteFile(lnxns.SubUIDFile, []byte(test.subuid), 0644)
+		}
+		if test.subgid != "" {
+			ioutil.WriteFile(lnxns.SubGIDFile, []byte(test.subgid), 0644)
+		}
+
+		uids, gids, delegated, err := lnxns.RootlessIDMaps()
+		if (err != nil) != test.err {
+			t.Fatalf("%s: RootlessIDMaps returned the wrong error: '%v'", test.name, err)
+		}
+		if !reflect.DeepEqual(uids, test.uids) || !reflect.DeepEqual(gids, test.gids) || delegated != test.delegated {
+			t.Fatalf("%s: RootlessIDMaps failed, Got: %v %v %v", test.name, uids, gids, delegated)
+		}
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
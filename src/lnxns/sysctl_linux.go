@@ -0,0 +1,175 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// kernel.* sysctls that belong to the IPC namespace, fs.mqueue.* is handled separately
+var ipcSysctls = []string{
+	"kernel.msgmax", "kernel.msgmnb", "kernel.msgmni", "kernel.msg_next_id",
+	"kernel.sem", "kernel.sem_next_id",
+	"kernel.shmall", "kernel.shmmax", "kernel.shmmni", "kernel.shm_next_id", "kernel.shm_rmid_forced",
+}
+
+// Sysctl reads and writes kernel parameters under /proc/sys using the same
+// dotted keys as sysctl(8), e.g. net.ipv4.ip_forward.
+type Sysctl struct {
+	vfs *Vfs
+}
+
+// create a Sysctl handle on top of a proc Vfs, usually ProcFs()
+// sc := NewSysctl(ProcFs())
+func NewSysctl(proc *Vfs) *Sysctl {
+	sc := Sysctl{
+		vfs: &Vfs{Mountpoint: path.Join(proc.Path(), "sys")},
+	}
+
+	return &sc
+}
+
+// convert a dotted key to its path relative to /proc/sys, keys that already
+// contain a slash are used as-is like sysctl(8) does
+// e.g. sysctlPath("net.ipv4.ip_forward") == "net/ipv4/ip_forward"
+func sysctlPath(key string) string {
+	if strings.Contains(key, "/") {
+		return key
+	}
+	return strings.Replace(key, ".", "/", -1)
+}
+
+// read a sysctl, multi-value keys like kernel.sem are returned with their fields
+// separated by single spaces
+// e.g. sc.Get("net.ipv4.tcp_congestion_control") = "cubic"
+func (sc *Sysctl) Get(key string) (value string, err error) {
+	var lines []string
+
+	parser := func(line string) {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+
+	err = sc.vfs.slurpLines(sysctlPath(key), parser)
+	return strings.Join(lines, "\n"), err
+}
+
+// write a sysctl
+// e.g. sc.Set("net.ipv4.ip_forward", "1")
+func (sc *Sysctl) Set(key string, value string) error {
+	return sc.vfs.SetString(sysctlPath(key), value)
+}
+
+// list every sysctl key the kernel exposes, in lexical order
+func (sc *Sysctl) List() (keys []string, err error) {
+	root := sc.vfs.Path()
+
+	walker := func(p string, fi os.FileInfo, werr error) error {
+		if werr != nil {
+			if p == root {
+				return werr
+			}
+			// unreadable entries are skipped, same as sysctl -a
+			if fi != nil && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		keys = append(keys, strings.Replace(rel, "/", ".", -1))
+		return nil
+	}
+
+	err = filepath.Walk(root, walker)
+	return
+}
+
+// returns the name of the namespace that owns a sysctl ("net", "ipc" or "uts"),
+// or an empty string when the key is global to the host
+func SysctlNamespace(key string) string {
+	key = strings.Replace(sysctlPath(key), "/", ".", -1)
+
+	switch {
+	case strings.HasPrefix(key, "net."):
+		return "net"
+	case strings.HasPrefix(key, "fs.mqueue."):
+		return "ipc"
+	case key == "kernel.hostname" || key == "kernel.domainname":
+		return "uts"
+	}
+
+	for _, ipc := range ipcSysctls {
+		if key == ipc {
+			return "ipc"
+		}
+	}
+
+	return ""
+}
+
+// the entry in spec for the namespace that owns a sysctl, an error when the key is
+// global to the host
+func sysctlNamespaceIn(spec *NamespaceSpec, key string) (*Namespace, error) {
+	switch SysctlNamespace(key) {
+	case "net":
+		return &spec.Net, nil
+	case "ipc":
+		return &spec.Ipc, nil
+	case "uts":
+		return &spec.Uts, nil
+	}
+	return nil, fmt.Errorf("sysctl '%s' is not namespaced and cannot be set in a container", key)
+}
+
+// write a set of sysctls inside the network/IPC/UTS namespaces of pid, e.g. a running
+// container. Every key must be namespaced; if any key is global to the host nothing is
+// written and an error is returned.
+func (sc *Sysctl) ApplyNamespaced(pid int, settings map[string]string) error {
+	spec := NamespaceSpec{}
+
+	for key := range settings {
+		ns, err := sysctlNamespaceIn(&spec, key)
+		if err != nil {
+			return err
+		}
+		*ns = JoinNamespacePid(pid)
+	}
+
+	apply := func() error {
+		for key, value := range settings {
+			if err := sc.Set(key, value); err != nil {
+				return fmt.Errorf("sysctl %s=%s: %s", key, value, err)
+			}
+		}
+		return nil
+	}
+
+	return RunInNamespaces(&spec, apply)
+}
+
+// check that every sysctl in a launch config belongs to a namespace the container
+// gets its own copy of, anything else would change the host
+func validateSysctls(spec *NamespaceSpec, settings map[string]string) error {
+	for key := range settings {
+		ns, err := sysctlNamespaceIn(spec, key)
+		if err != nil {
+			return err
+		}
+
+		if ns.Mode != NamespaceNew {
+			return fmt.Errorf("sysctl '%s' needs a new %s namespace", key, SysctlNamespace(key))
+		}
+	}
+
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
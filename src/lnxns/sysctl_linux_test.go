@@ -0,0 +1,73 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSysctl(t *testing.T) {
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-sysctl")
+	defer os.RemoveAll(tmpPath)
+
+	os.MkdirAll(path.Join(tmpPath, "sys", "net", "ipv4"), 0755)
+	os.MkdirAll(path.Join(tmpPath, "sys", "kernel"), 0755)
+
+	proc, _ := lnxns.NewVfs(tmpPath)
+	sc := lnxns.NewSysctl(proc)
+
+	err := sc.Set("net.ipv4.ip_forward", "1")
+	if err != nil {
+		t.Fatalf("Set returned an error! '%s'", err)
+	}
+	ioutil.WriteFile(path.Join(tmpPath, "sys", "kernel", "sem"), []byte("32000\t1024000000\t500\t32000\n"), 0644)
+
+	fwd, err := sc.Get("net.ipv4.ip_forward")
+	if err != nil || fwd != "1" {
+		t.Fatalf("Get failed, Got: '%s', '%v'", fwd, err)
+	}
+
+	sem, err := sc.Get("kernel/sem")
+	if err != nil || sem != "32000 1024000000 500 32000" {
+		t.Fatalf("Get failed, Got: '%s', '%v'", sem, err)
+	}
+
+	keys, err := sc.List()
+	if err != nil {
+		t.Fatalf("List returned an error! '%s'", err)
+	}
+	if fmt.Sprint(keys) != "[kernel.sem net.ipv4.ip_forward]" {
+		t.Fatalf("List failed, Got: '%v'", keys)
+	}
+
+	expected := map[string]string{
+		"net.ipv4.ip_forward":   "net",
+		"kernel.shmmax":         "ipc",
+		"kernel/sem":            "ipc",
+		"fs.mqueue.msg_max":     "ipc",
+		"kernel.hostname":       "uts",
+		"kernel.shmmaxx":        "",
+		"vm.swappiness":         "",
+		"kernel.core_pattern":   "",
+		"netfilter.nf_whatever": "",
+	}
+	for key, ns := range expected {
+		if got := lnxns.SysctlNamespace(key); got != ns {
+			t.Fatalf("SysctlNamespace(%q) failed, Expected '%s', Got '%s'", key, ns, got)
+		}
+	}
+
+	err = sc.ApplyNamespaced(os.Getpid(), map[string]string{"net.ipv4.ip_forward": "1", "vm.swappiness": "0"})
+	if err == nil {
+		t.Fatalf("ApplyNamespaced returned a nil error for a global sysctl.")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,80 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+// TimeOffsets shift the clocks seen in a new time namespace, see time_namespaces(7).
+// e.g. TimeOffsets{Boottime: 30 * 24 * time.Hour} makes a container think the
+// machine has been up for an extra month. Offsets may be negative.
+type TimeOffsets struct {
+	Monotonic time.Duration // CLOCK_MONOTONIC, CLOCK_MONOTONIC_RAW and CLOCK_MONOTONIC_COARSE
+	Boottime  time.Duration // CLOCK_BOOTTIME, which is also what /proc/uptime shows
+}
+
+// true when there is nothing to write
+func (o TimeOffsets) IsZero() bool {
+	return o.Monotonic == 0 && o.Boottime == 0
+}
+
+// the timens_offsets format, e.g. "monotonic 86400 0\nboottime -1 500000000\n"
+func (o TimeOffsets) format() []byte {
+	var buf bytes.Buffer
+	for _, clock := range []struct {
+		name   string
+		offset time.Duration
+	}{{"monotonic", o.Monotonic}, {"boottime", o.Boottime}} {
+		if clock.offset == 0 {
+			continue
+		}
+
+		// the nanoseconds field can't be negative, so -1.5s is -2s + 0.5s
+		secs := clock.offset / time.Second
+		nsecs := clock.offset - secs*time.Second
+		if nsecs < 0 {
+			secs--
+			nsecs += time.Second
+		}
+		fmt.Fprintf(&buf, "%s %d %d\n", clock.name, int64(secs), int64(nsecs))
+	}
+	return buf.Bytes()
+}
+
+// called in the init stage. A new time namespace only applies to children of the
+// process that unshares it, or to the process itself after its next execve(2), and
+// its offsets can only be set until then.
+func unshareTimeNs(offsets TimeOffsets) error {
+	if err := syscall.Unshare(CLONE_NEWTIME); err != nil {
+		return fmt.Errorf("unshare CLONE_NEWTIME: %s", err)
+	}
+
+	if offsets.IsZero() {
+		return nil
+	}
+
+	// the namespace was unshared by this locked thread, which might not be the
+	// thread group leader that /proc/self describes. /proc/thread-self has no
+	// timens_offsets, but /proc/<tid> of a thread does.
+	proc := ProcFs().Path()
+	self, err := os.Readlink(path.Join(proc, "thread-self"))
+	if err != nil {
+		return err
+	}
+	p := path.Join(proc, path.Base(self), "timens_offsets")
+	if err := ioutil.WriteFile(p, offsets.format(), 0); err != nil {
+		return fmt.Errorf("could not write timens_offsets: %s", err)
+	}
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
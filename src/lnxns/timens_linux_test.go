@@ -0,0 +1,57 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func uptime(t *testing.T, s string) time.Duration {
+	secs, err := strconv.ParseFloat(strings.Fields(s)[0], 64)
+	if err != nil {
+		t.Fatalf("could not parse /proc/uptime '%s': %s", s, err)
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+func TestTimeOffsets(t *testing.T) {
+	_, err := lnxns.Launch(&lnxns.Config{
+		Path:        "true",
+		TimeOffsets: lnxns.TimeOffsets{Boottime: time.Hour},
+	})
+	if err == nil {
+		t.Fatalf("Launch with time offsets and no time namespace should fail.")
+	}
+
+	host, err := lnxns.ProcFs().GetString("uptime")
+	if err != nil {
+		t.Fatalf("could not read /proc/uptime: %s", err)
+	}
+
+	offsets := lnxns.TimeOffsets{Monotonic: -1500 * time.Millisecond, Boottime: 10 * 24 * time.Hour}
+	var out bytes.Buffer
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:        "cat",
+		Args:        []string{"cat", "/proc/uptime"},
+		Namespaces:  &lnxns.NamespaceSpec{Time: lnxns.NewNamespace()},
+		TimeOffsets: offsets,
+		Stdout:      &out,
+	})
+	if res, err := c.Wait(); err != nil || !res.Success() {
+		t.Fatalf("the container failed: %v, %v", res, err)
+	}
+
+	diff := uptime(t, out.String()) - uptime(t, host)
+	if diff < offsets.Boottime || diff > offsets.Boottime+time.Minute {
+		t.Fatalf("the container's uptime is off by %s, expected %s", diff, offsets.Boottime)
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,87 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// where UnpackRootfs keeps root filesystems by default
+var RootfsCacheDir = "/var/lib/lnxns/rootfs"
+
+// unpack a tar or tar.gz root filesystem into cacheDir/<sha256 of the tarball> and
+// return that path, ready to be used as a Config.Root. A tarball that was unpacked
+// before is not unpacked again, so the tree must be treated as read-only, e.g. by
+// using it as an Overlay layer.
+func UnpackRootfs(cacheDir, tarball string) (string, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	rootfs := path.Join(cacheDir, hex.EncodeToString(hash.Sum(nil)))
+
+	if _, err = os.Stat(rootfs); err == nil {
+		return rootfs, nil
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	err = unpackCached(rootfs, func(dir string) error {
+		return ApplyLayer(dir, f)
+	})
+	if err != nil {
+		return "", err
+	}
+	return rootfs, nil
+}
+
+// fill a directory next to rootfs and rename it into place, so the cache never has
+// a partial tree and concurrent unpacks of the same content don't collide
+func unpackCached(rootfs string, fill func(dir string) error) error {
+	cacheDir := path.Dir(rootfs)
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempDir(cacheDir, ".unpack-")
+	if err != nil {
+		return err
+	}
+
+	// TempDir makes it 0700, which as a container's / locks out everyone but root.
+	// A ./ entry in a tarball sets it again.
+	if err = os.Chmod(tmp, 0755); err == nil {
+		err = fill(tmp)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, rootfs); err != nil {
+		os.RemoveAll(tmp)
+		// someone else was faster
+		if _, serr := os.Stat(rootfs); serr == nil {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
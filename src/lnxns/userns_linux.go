@@ -0,0 +1,76 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// IDMap is one line of a uid_map or gid_map, see user_namespaces(7).
+// e.g. IDMap{ContainerID: 0, HostID: 1000, Size: 1} makes uid 1000 root in the container
+type IDMap struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// map container root to the launcher's own uid and gid, same as unshare -r
+func defaultIDMaps() (uids []IDMap, gids []IDMap) {
+	uids = []IDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+	gids = []IDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+	return
+}
+
+// the uid_map/gid_map file format, which has to be written with a single write(2)
+func formatIDMaps(maps []IDMap) []byte {
+	var buf bytes.Buffer
+	for _, m := range maps {
+		fmt.Fprintf(&buf, "%d %d %d\n", m.ContainerID, m.HostID, m.Size)
+	}
+	return buf.Bytes()
+}
+
+// write the id maps of a process that was just started in a new user namespace.
+// denySetgroups disables setgroups(2) in the namespace, which the kernel requires
+// before an unprivileged process may write gid_map. Maps an unprivileged caller
+// can't write directly are handed to newuidmap/newgidmap.
+func writeIDMaps(pid int, uids []IDMap, gids []IDMap, denySetgroups bool) error {
+	procDir := path.Join(ProcFs().Path(), strconv.Itoa(pid))
+
+	if needsIDMapHelper(uids, os.Geteuid(), os.Geteuid()) {
+		if err := runIDMapHelper("newuidmap", pid, uids); err != nil {
+			return err
+		}
+	} else if len(uids) > 0 {
+		if err := ioutil.WriteFile(path.Join(procDir, "uid_map"), formatIDMaps(uids), 0); err != nil {
+			return fmt.Errorf("could not write uid_map: %s", err)
+		}
+	}
+
+	if denySetgroups {
+		if err := ioutil.WriteFile(path.Join(procDir, "setgroups"), []byte("deny"), 0); err != nil {
+			return fmt.Errorf("could not write setgroups: %s", err)
+		}
+	}
+
+	if needsIDMapHelper(gids, os.Geteuid(), os.Getegid()) {
+		if err := runIDMapHelper("newgidmap", pid, gids); err != nil {
+			return err
+		}
+	} else if len(gids) > 0 {
+		if err := ioutil.WriteFile(path.Join(procDir, "gid_map"), formatIDMaps(gids), 0); err != nil {
+			return fmt.Errorf("could not write gid_map: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
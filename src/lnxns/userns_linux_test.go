@@ -0,0 +1,69 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLaunchUserns(t *testing.T) {
+	spec := lnxns.DefaultNamespaceSpec()
+	spec.User = lnxns.NewNamespace()
+
+	// hostname only works if the command really has CAP_SYS_ADMIN in the new namespace
+	var out bytes.Buffer
+	c := launchOrSkip(t, &lnxns.Config{
+		Path:       "sh",
+		Args:       []string{"sh", "-c", "cat /proc/self/uid_map /proc/self/gid_map /proc/self/setgroups; hostname userns && hostname"},
+		Namespaces: spec,
+		UidMappings: []lnxns.IDMap{
+			{ContainerID: 0, HostID: 0, Size: 1},
+			{ContainerID: 1, HostID: 100000, Size: 1000},
+		},
+		GidMappings:   []lnxns.IDMap{{ContainerID: 0, HostID: 0, Size: 1}},
+		DenySetgroups: true,
+		Stdout:        &out,
+	})
+	if res, err := c.Wait(); err != nil || !res.Success() {
+		t.Fatalf("Wait returned an error! '%v', '%v', output: '%s'", res, err, out.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("unexpected output from the container: '%s'", out.String())
+	}
+	if strings.Fields(lines[1])[1] != "100000" || strings.Fields(lines[2])[2] != "1" {
+		t.Fatalf("id maps are wrong: '%s'", out.String())
+	}
+	if lines[3] != "deny" || lines[4] != "userns" {
+		t.Fatalf("setgroups or hostname are wrong: '%s'", out.String())
+	}
+}
+
+func TestLaunchUsernsSysfs(t *testing.T) {
+	// without its own network namespace the container can't mount sysfs and binds
+	// the host's /sys, submounts like /sys/fs/cgroup included
+	spec := lnxns.DefaultNamespaceSpec()
+	spec.User = lnxns.NewNamespace()
+
+	cfg := lnxns.Config{
+		Namespaces:  spec,
+		UidMappings: []lnxns.IDMap{{ContainerID: 0, HostID: 0, Size: 1}},
+		GidMappings: []lnxns.IDMap{{ContainerID: 0, HostID: 0, Size: 1}},
+	}
+	// print the writable mounts under /sys and whether there was more than one
+	script := `n=0; while read -r id parent dev root mp opts rest; do case $mp in /sys|/sys/*) n=$((n+1));` +
+		`case $opts in ro|ro,*) ;; *) echo rw $mp ;; esac ;; esac; done < /proc/self/mountinfo; echo $((n > 1))`
+	got := runInRootfs(t, &cfg, script)
+
+	if got != "1\n" {
+		t.Fatalf("the host's /sys is not read-only all the way down, Got: '%s'", got)
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -211,6 +211,129 @@ func (vfs *Vfs) GetMapList(name string, keyIndex int) (values map[string][]strin
 	return
 }
 
+// read a flat keyed file where every line is "key value", e.g. memory.stat or /proc/meminfo.
+// A trailing colon on the key is dropped and anything after the value (like "kB") is ignored.
+// e.g. ProcFs().GetKeyed("meminfo")["MemTotal"] = "16314484"
+func (vfs *Vfs) GetKeyed(name string) (values map[string]string, err error) {
+	values = make(map[string]string)
+
+	parser := func(parts []string) {
+		if len(parts) < 2 {
+			return
+		}
+		values[strings.TrimSuffix(parts[0], ":")] = parts[1]
+	}
+
+	err = vfs.slurp(name, parser)
+	return
+}
+
+// same as GetKeyed but with every value converted to an integer
+// e.g. cgvfs.GetKeyedInt("memory/memory.stat")["rss"] = 1052672
+func (vfs *Vfs) GetKeyedInt(name string) (values map[string]int64, err error) {
+	values = make(map[string]int64)
+
+	kv, err := vfs.GetKeyed(name)
+	if err != nil {
+		return
+	}
+
+	for key, value := range kv {
+		var num int64
+		if num, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("%s: key '%s': %s", name, key, err)
+		}
+		values[key] = num
+	}
+
+	return
+}
+
+// read a nested keyed file where every line is a key followed by key=value pairs
+// e.g. cgvfs.GetNestedKeyed("io.stat")["8:0"]["rbytes"] = "90112"
+// Fields without an = are stored with an empty value.
+func (vfs *Vfs) GetNestedKeyed(name string) (values map[string]map[string]string, err error) {
+	values = make(map[string]map[string]string)
+
+	parser := func(parts []string) {
+		nested := make(map[string]string)
+		for _, part := range parts[1:] {
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) == 2 {
+				nested[kv[0]] = kv[1]
+			} else {
+				nested[kv[0]] = ""
+			}
+		}
+		values[parts[0]] = nested
+	}
+
+	err = vfs.slurp(name, parser)
+	return
+}
+
+// read a colon-separated file where every line is "Key:<whitespace>value", as in /proc/<pid>/status.
+// The value is everything after the first colon with surrounding whitespace removed.
+// e.g. ProcFs().GetColonMap("self/status")["State"] = "R (running)"
+func (vfs *Vfs) GetColonMap(name string) (values map[string]string, err error) {
+	values = make(map[string]string)
+
+	parser := func(line string) {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return
+		}
+		values[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	err = vfs.slurpLines(name, parser)
+	return
+}
+
+// read a range list like "0-3,7" and expand it to the list of integers it contains
+// e.g. cgvfs.GetRangeList("cpuset/cpuset.cpus") = [ 0, 1, 2, 3, 7 ]
+func (vfs *Vfs) GetRangeList(name string) (values []int, err error) {
+	value, err := vfs.GetString(name)
+	if err != nil {
+		return
+	}
+
+	return ParseRangeList(value)
+}
+
+// expand a kernel range list like "0-3,7" to [ 0, 1, 2, 3, 7 ]. An empty string is an empty list.
+func ParseRangeList(list string) (values []int, err error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return
+	}
+
+	for _, item := range strings.Split(list, ",") {
+		var first, last int
+		bounds := strings.SplitN(item, "-", 2)
+
+		if first, err = strconv.Atoi(bounds[0]); err != nil {
+			return nil, fmt.Errorf("invalid range list '%s': %s", list, err)
+		}
+		last = first
+
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid range list '%s': %s", list, err)
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid range list '%s': %d-%d is backwards", list, first, last)
+			}
+		}
+
+		for i := first; i <= last; i++ {
+			values = append(values, i)
+		}
+	}
+
+	return
+}
+
 // write a string
 func (vfs *Vfs) SetString(name string, value string) (err error) {
 	return vfs.write(name, value)
@@ -229,8 +352,24 @@ func (vfs *Vfs) Files() ([]string, error) {
 	return []string{"cgroup.procs", "cpuset.mem_hardwall", "cpuset.memory_spread_page", "cpuset.sched_relax_domain_level", "tasks"}, nil
 }
 
-// read a file line-by-line calling the provided function for each line
+// read a file line-by-line calling the provided function with the whitespace-separated
+// fields of each line
 func (vfs *Vfs) slurp(name string, cb func([]string)) (err error) {
+	parser := func(line string) {
+		var parts []string
+		for _, part := range strings.Fields(line) {
+			parts = append(parts, strings.TrimSpace(part))
+		}
+		if len(parts) > 0 {
+			cb(parts)
+		}
+	}
+
+	return vfs.slurpLines(name, parser)
+}
+
+// read a file line-by-line calling the provided function for each raw line
+func (vfs *Vfs) slurpLines(name string, cb func(string)) (err error) {
 	var (
 		file *os.File
 		pt   string = path.Join(vfs.Mountpoint, name)
@@ -243,16 +382,11 @@ func (vfs *Vfs) slurp(name string, cb func([]string)) (err error) {
 
 	reader := bufio.NewReader(file)
 
-	// hmm this could be fmt.Scan*?
 	for {
 		var line string
 		line, err = reader.ReadString('\n')
 		if line != "" {
-			var parts []string
-			for _, part := range strings.Fields(line) {
-				parts = append(parts, strings.TrimSpace(part))
-			}
-			cb(parts)
+			cb(strings.TrimRight(line, "\n"))
 		}
 		if err == io.EOF {
 			err = nil
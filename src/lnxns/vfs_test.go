@@ -6,6 +6,7 @@ package lnxns_test
 
 import (
 	"../../src/lnxns"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
@@ -69,4 +70,65 @@ func TestNewVfs(t *testing.T) {
 	}
 }
 
+func TestVfsParsers(t *testing.T) {
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-vfs-parsers")
+	defer os.RemoveAll(tmpPath)
+
+	vr, err := lnxns.NewVfs(tmpPath)
+	if err != nil {
+		t.Fatalf("NewVfs %q: %s", tmpPath, err)
+	}
+
+	vr.SetString("meminfo", "MemTotal:       16314484 kB\nMemFree:         8123456 kB\n\nHugePages_Total:       0")
+	kv, err := vr.GetKeyedInt("meminfo")
+	if err != nil {
+		t.Fatalf("GetKeyedInt returned an error! '%s'", err)
+	}
+	if kv["MemTotal"] != 16314484 || kv["MemFree"] != 8123456 || len(kv) != 3 {
+		t.Fatalf("GetKeyedInt failed, Got: '%v'", kv)
+	}
+
+	vr.SetString("memory.stat", "cache 4096\nrss max")
+	if _, err = vr.GetKeyedInt("memory.stat"); err == nil {
+		t.Fatalf("GetKeyedInt returned a nil error for a non-integer value.")
+	}
+
+	vr.SetString("io.stat", "8:0 rbytes=90112 wbytes=0 dbytes=0\n253:1 rbytes=1 wbytes=2")
+	nested, err := vr.GetNestedKeyed("io.stat")
+	if err != nil {
+		t.Fatalf("GetNestedKeyed returned an error! '%s'", err)
+	}
+	if nested["8:0"]["rbytes"] != "90112" || nested["253:1"]["wbytes"] != "2" || len(nested) != 2 {
+		t.Fatalf("GetNestedKeyed failed, Got: '%v'", nested)
+	}
+
+	vr.SetString("status", "Name:\tbash\nState:\tS (sleeping)\nUid:\t1000\t1000\t1000\t1000")
+	colon, err := vr.GetColonMap("status")
+	if err != nil {
+		t.Fatalf("GetColonMap returned an error! '%s'", err)
+	}
+	if colon["Name"] != "bash" || colon["State"] != "S (sleeping)" || colon["Uid"] != "1000\t1000\t1000\t1000" {
+		t.Fatalf("GetColonMap failed, Got: '%v'", colon)
+	}
+
+	vr.SetString("cpuset.cpus", "0-3,7")
+	cpus, err := vr.GetRangeList("cpuset.cpus")
+	if err != nil {
+		t.Fatalf("GetRangeList returned an error! '%s'", err)
+	}
+	if fmt.Sprint(cpus) != "[0 1 2 3 7]" {
+		t.Fatalf("GetRangeList failed, Got: '%v'", cpus)
+	}
+
+	for _, bad := range []string{"3-1", "a", "1-", "1,,2"} {
+		if _, err = lnxns.ParseRangeList(bad); err == nil {
+			t.Fatalf("ParseRangeList(%q) returned a nil error where a real error was expected.", bad)
+		}
+	}
+
+	if empty, err := lnxns.ParseRangeList("\n"); err != nil || len(empty) != 0 {
+		t.Fatalf("ParseRangeList of an empty list failed, Got: '%v', '%v'", empty, err)
+	}
+}
+
 // vim: ts=4 sw=4 noet tw=120 softtabstop=4
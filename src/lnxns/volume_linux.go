@@ -0,0 +1,212 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Volume is a host path bind-mounted into a container's Root, see Config.Volumes.
+type Volume struct {
+	Source      string   // absolute path on the host, a file or a directory
+	Destination string   // absolute path in the container, created when it's missing
+	ReadOnly    bool     // applies to the volume itself, not to mounts below Source
+	Propagation string   // private, rprivate, slave, rslave, shared or rshared, mounts are private by default
+	Options     []string // any of nosuid, nodev and noexec
+}
+
+var volumePropagation = map[string]uintptr{
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+}
+
+var volumeOptions = map[string]uintptr{
+	"nosuid": syscall.MS_NOSUID,
+	"nodev":  syscall.MS_NODEV,
+	"noexec": syscall.MS_NOEXEC,
+}
+
+// parse a volume in the docker -v format, host:container[:options], where options
+// is a comma separated list of ro, rw, a propagation mode and the Volume.Options.
+// A relative host path is taken relative to the current directory.
+// e.g. ParseVolume("/usr:/usr:ro,rslave")
+func ParseVolume(spec string) (Volume, error) {
+	var vol Volume
+
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return vol, fmt.Errorf("invalid volume '%s', expected host:container[:options]", spec)
+	}
+
+	source, err := filepath.Abs(parts[0])
+	if err != nil {
+		return vol, err
+	}
+	vol.Source = source
+	vol.Destination = parts[1]
+
+	if len(parts) == 3 {
+		for _, opt := range strings.Split(parts[2], ",") {
+			if opt == "ro" {
+				vol.ReadOnly = true
+			} else if opt == "rw" {
+				vol.ReadOnly = false
+			} else if _, ok := volumePropagation[opt]; ok {
+				vol.Propagation = opt
+			} else {
+				vol.Options = append(vol.Options, opt)
+			}
+		}
+	}
+
+	return vol, vol.validate()
+}
+
+// VolumeList collects a repeatable -v flag with ParseVolume, e.g.
+// flag.Var(&volumes, "v", "host:container[:options], can be repeated")
+type VolumeList []Volume
+
+func (vl *VolumeList) String() string {
+	return fmt.Sprint(*vl)
+}
+
+func (vl *VolumeList) Set(spec string) error {
+	vol, err := ParseVolume(spec)
+	if err != nil {
+		return err
+	}
+	*vl = append(*vl, vol)
+	return nil
+}
+
+func (vol Volume) String() string {
+	opts := vol.Options
+	if vol.ReadOnly {
+		opts = append([]string{"ro"}, opts...)
+	}
+	if vol.Propagation != "" {
+		opts = append(opts, vol.Propagation)
+	}
+
+	if len(opts) == 0 {
+		return vol.Source + ":" + vol.Destination
+	}
+	return vol.Source + ":" + vol.Destination + ":" + strings.Join(opts, ",")
+}
+
+func (vol *Volume) validate() error {
+	if !path.IsAbs(vol.Source) || !path.IsAbs(vol.Destination) {
+		return fmt.Errorf("volume %s: both paths must be absolute", vol)
+	}
+	if _, ok := volumePropagation[vol.Propagation]; !ok && vol.Propagation != "" {
+		return fmt.Errorf("volume %s: unknown propagation '%s'", vol, vol.Propagation)
+	}
+	for _, opt := range vol.Options {
+		if _, ok := volumeOptions[opt]; !ok {
+			return fmt.Errorf("volume %s: unknown option '%s'", vol, opt)
+		}
+	}
+	return nil
+}
+
+// bind the volume into the container's root filesystem, before it is pivoted to
+func mountVolume(root string, vol *Volume) error {
+	dest, err := resolveInRoot(root, vol.Destination)
+	if err != nil {
+		return err
+	}
+
+	st, err := os.Stat(vol.Source)
+	if err != nil {
+		return fmt.Errorf("volume %s: %s", vol, err)
+	}
+
+	// bind mounts need something of the same kind to mount on
+	if st.IsDir() {
+		err = os.MkdirAll(dest, 0755)
+	} else if err = os.MkdirAll(path.Dir(dest), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(dest, os.O_CREATE, 0644); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("volume %s: could not create the mount point: %s", vol, err)
+	}
+
+	if err = syscall.Mount(vol.Source, dest, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("volume %s: %s", vol, err)
+	}
+
+	// the flags of a bind mount can only be changed by remounting it
+	var flags uintptr
+	if vol.ReadOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	for _, opt := range vol.Options {
+		flags |= volumeOptions[opt]
+	}
+	if flags != 0 {
+		if err = remountBind(dest, flags); err != nil {
+			return err
+		}
+	}
+
+	if vol.Propagation != "" {
+		if err = syscall.Mount("", dest, "", volumePropagation[vol.Propagation], ""); err != nil {
+			return fmt.Errorf("volume %s: could not make it %s: %s", vol, vol.Propagation, err)
+		}
+	}
+
+	return nil
+}
+
+// join p to root, following symlinks as if root was / so an absolute symlink in
+// the container's filesystem can't send a mount out to the host's
+func resolveInRoot(root, p string) (string, error) {
+	resolved := "/"
+	parts := strings.Split(p, "/")
+
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+
+		if part == "" || part == "." {
+			continue
+		} else if part == ".." {
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		target, err := os.Readlink(path.Join(root, next))
+		if err != nil {
+			// not a symlink, or not there yet
+			resolved = next
+			continue
+		}
+
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	return path.Join(root, resolved), nil
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
@@ -0,0 +1,116 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	vol, err := lnxns.ParseVolume("/usr:/usr:ro,rslave,nosuid")
+	expected := lnxns.Volume{
+		Source:      "/usr",
+		Destination: "/usr",
+		ReadOnly:    true,
+		Propagation: "rslave",
+		Options:     []string{"nosuid"},
+	}
+	if err != nil || !reflect.DeepEqual(vol, expected) {
+		t.Fatalf("ParseVolume failed: %s, Expected: %+v Got: %+v", err, expected, vol)
+	}
+	if vol.String() != "/usr:/usr:ro,nosuid,rslave" {
+		t.Fatalf("Volume.String() returned '%s'", vol.String())
+	}
+
+	// relative host paths are relative to the current directory
+	wd, _ := os.Getwd()
+	vol, err = lnxns.ParseVolume("src:/src")
+	if err != nil || vol.Source != path.Join(wd, "src") || vol.ReadOnly {
+		t.Fatalf("ParseVolume failed on a relative path: %s, Got: %+v", err, vol)
+	}
+
+	for _, bad := range []string{"/usr", "/usr:", ":/usr", "/usr:usr", "/usr:/usr:ro:x", "/usr:/usr:bogus"} {
+		if _, err = lnxns.ParseVolume(bad); err == nil {
+			t.Fatalf("ParseVolume('%s') should fail.", bad)
+		}
+	}
+
+	// as a repeatable flag
+	var volumes lnxns.VolumeList
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Var(&volumes, "v", "volumes")
+	if err = fs.Parse([]string{"-v", "/usr:/usr:ro", "-v", "/srv:/data"}); err != nil || len(volumes) != 2 {
+		t.Fatalf("VolumeList did not collect both volumes: %v, Got: %v", err, volumes)
+	}
+	if volumes[1].Destination != "/data" || volumes.String() != "[/usr:/usr:ro /srv:/data]" {
+		t.Fatalf("VolumeList failed, Got: '%s'", volumes.String())
+	}
+	if err = fs.Parse([]string{"-v", "/usr"}); err == nil {
+		t.Fatalf("VolumeList should reject a bad volume")
+	}
+}
+
+func TestLaunchVolumes(t *testing.T) {
+	root, cleanup := testRootfs(t)
+	defer cleanup()
+
+	src, err := ioutil.TempDir("", "lnxns-volume-")
+	if err != nil {
+		t.Fatalf("Could not create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(src)
+	ioutil.WriteFile(path.Join(src, "hello"), []byte("hello\n"), 0644)
+
+	// an absolute symlink has to stay inside the container's root
+	escape := path.Join(src, "escape")
+	os.Symlink(escape, path.Join(root, "link"))
+
+	var out bytes.Buffer
+	c := launchOrSkip(t, &lnxns.Config{
+		Path: "sh",
+		Args: []string{"sh", "-c", `cat /src/hello /file; echo new > /src/new; touch /ro/x 2>/dev/null || echo ro`},
+		Root: root,
+		Volumes: []lnxns.Volume{
+			{Source: src, Destination: "/src"},
+			{Source: src, Destination: "/ro", ReadOnly: true},
+			{Source: path.Join(src, "hello"), Destination: "/file"},
+			{Source: src, Destination: "/link/mnt"},
+		},
+		Stdout: &out,
+		Stderr: &out,
+	})
+	res, err := c.Wait()
+	if err != nil || !res.Success() {
+		t.Fatalf("the command failed: %v %v, Output: '%s'", res, err, out.String())
+	}
+
+	if out.String() != "hello\nhello\nro\n" {
+		t.Fatalf("the volumes were not mounted, Got: '%s'", out.String())
+	}
+	if data, _ := ioutil.ReadFile(path.Join(src, "new")); string(data) != "new\n" {
+		t.Fatalf("a write to a volume did not reach the host, Got: '%s'", data)
+	}
+	if _, err = os.Stat(escape); err == nil {
+		t.Fatalf("a volume followed a symlink out of the container's root")
+	}
+	if _, err = os.Stat(path.Join(root, escape, "mnt")); err != nil {
+		t.Fatalf("a volume under a symlink was not mounted in the container's root: %s", err)
+	}
+
+	_, err = lnxns.Launch(&lnxns.Config{Path: "true", Volumes: []lnxns.Volume{{Source: src, Destination: "/src"}}})
+	if err == nil {
+		t.Fatalf("Launch with volumes but no Root should fail.")
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
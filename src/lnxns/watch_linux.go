@@ -0,0 +1,226 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// filesystem magic numbers from /usr/include/linux/magic.h
+const (
+	PROC_SUPER_MAGIC    = 0x9fa0
+	SYSFS_MAGIC         = 0x62656572
+	CGROUP_SUPER_MAGIC  = 0x27e0eb
+	CGROUP2_SUPER_MAGIC = 0x63677270
+)
+
+// how often files that can't be watched with inotify are re-read
+var WatchPollInterval = time.Second
+
+// VfsEvent is delivered every time a watched file's content changes.
+type VfsEvent struct {
+	Name  string
+	Value string
+}
+
+// VfsWatcher watches one file in a Vfs. Events is closed when the watcher
+// stops, check Err() to see why.
+type VfsWatcher struct {
+	Events   chan VfsEvent
+	name     string
+	path     string
+	ifd      int // inotify fd, -1 when polling
+	interval time.Duration
+	ep       *epoller
+	last     string
+	done     chan struct{}
+	once     sync.Once
+	err      error
+}
+
+// watch a file for changes, e.g. cgvfs.Watch("tobert/cgroup.events")
+// inotify is used where the kernel supports it: cgroup2 *.events files and regular
+// filesystems. Everything else, like /proc and most of sysfs, never generates inotify
+// events so those files are polled every WatchPollInterval instead.
+func (vfs *Vfs) Watch(name string) (*VfsWatcher, error) {
+	pt := path.Join(vfs.Mountpoint, name)
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(pt, &st); err != nil {
+		return nil, err
+	}
+
+	switch st.Type {
+	case CGROUP2_SUPER_MAGIC:
+		if strings.HasSuffix(name, ".events") {
+			return vfs.watch(name, 0)
+		}
+		return vfs.watch(name, WatchPollInterval)
+	case PROC_SUPER_MAGIC, SYSFS_MAGIC, CGROUP_SUPER_MAGIC:
+		return vfs.watch(name, WatchPollInterval)
+	}
+
+	return vfs.watch(name, 0)
+}
+
+// watch a file by re-reading it every interval, for files where Watch() guesses wrong
+func (vfs *Vfs) WatchPoll(name string, interval time.Duration) (*VfsWatcher, error) {
+	return vfs.watch(name, interval)
+}
+
+// set up a watcher, an interval of 0 means use inotify
+func (vfs *Vfs) watch(name string, interval time.Duration) (*VfsWatcher, error) {
+	w := VfsWatcher{
+		Events:   make(chan VfsEvent, 16),
+		name:     name,
+		path:     path.Join(vfs.Mountpoint, name),
+		ifd:      -1,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+
+	var err error
+	if w.ep, err = newEpoller(); err != nil {
+		return nil, err
+	}
+
+	if interval == 0 {
+		if w.ifd, err = syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK); err != nil {
+			w.cleanup()
+			return nil, err
+		}
+
+		mask := uint32(syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF)
+		if _, err = syscall.InotifyAddWatch(w.ifd, w.path, mask); err != nil {
+			w.cleanup()
+			return nil, err
+		}
+
+		if err = w.ep.add(w.ifd, syscall.EPOLLIN); err != nil {
+			w.cleanup()
+			return nil, err
+		}
+	}
+
+	// changes are relative to the content at the time the watch started
+	if w.last, err = w.read(); err != nil {
+		w.cleanup()
+		return nil, err
+	}
+
+	go w.loop()
+
+	return &w, nil
+}
+
+// stop watching, Events is closed once the watcher goroutine exits
+func (w *VfsWatcher) Close() error {
+	w.once.Do(func() {
+		close(w.done)
+		w.ep.wake()
+	})
+	return nil
+}
+
+// the error that stopped the watcher, nil after a normal Close()
+func (w *VfsWatcher) Err() error {
+	return w.err
+}
+
+func (w *VfsWatcher) loop() {
+	defer close(w.Events)
+	defer w.cleanup()
+
+	timeout := -1
+	if w.ifd < 0 {
+		timeout = int(w.interval / time.Millisecond)
+	}
+
+	for {
+		ready, woken, err := w.ep.wait(timeout)
+		if err != nil {
+			w.err = err
+			return
+		}
+		if woken {
+			return
+		}
+
+		if len(ready) > 0 {
+			gone, err := w.drain()
+			if err != nil {
+				w.err = err
+				return
+			}
+			if gone {
+				w.err = syscall.ENOENT
+				return
+			}
+		} else if w.ifd >= 0 {
+			continue
+		}
+
+		value, err := w.read()
+		if err != nil {
+			w.err = err
+			return
+		}
+		if value == w.last {
+			continue
+		}
+		w.last = value
+
+		select {
+		case w.Events <- VfsEvent{Name: w.name, Value: value}:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// empty the inotify queue, returns true if the file was deleted or moved away
+func (w *VfsWatcher) drain() (gone bool, err error) {
+	buf := make([]byte, syscall.SizeofInotifyEvent*64+syscall.NAME_MAX+1)
+
+	for {
+		n, err := syscall.Read(w.ifd, buf)
+		if err == syscall.EAGAIN {
+			return gone, nil
+		} else if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return gone, err
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			if ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+				gone = true
+			}
+			offset += syscall.SizeofInotifyEvent + int(ev.Len)
+		}
+	}
+}
+
+// the current content of the file with surrounding whitespace removed
+func (w *VfsWatcher) read() (string, error) {
+	data, err := ioutil.ReadFile(w.path)
+	return strings.TrimSpace(string(data)), err
+}
+
+func (w *VfsWatcher) cleanup() {
+	w.ep.close()
+	if w.ifd >= 0 {
+		syscall.Close(w.ifd)
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4
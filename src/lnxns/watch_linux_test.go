@@ -0,0 +1,72 @@
+// Copyright 2013 Albert P. Tobey. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lnxns_test
+
+import (
+	"../../src/lnxns"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVfsWatch(t *testing.T) {
+	tmpPath, _ := ioutil.TempDir(os.TempDir(), "test-lnxns-watch")
+	defer os.RemoveAll(tmpPath)
+
+	vr, _ := lnxns.NewVfs(tmpPath)
+	vr.SetString("cgroup.events", "populated 0\nfrozen 0")
+	vr.SetString("memory.current", "4096")
+
+	if _, err := vr.Watch("does-not-exist"); err == nil {
+		t.Fatalf("Watch returned a nil error for a missing file.")
+	}
+
+	inotify, err := vr.Watch("cgroup.events")
+	if err != nil {
+		t.Fatalf("Watch returned an error! '%s'", err)
+	}
+	defer inotify.Close()
+
+	poll, err := vr.WatchPoll("memory.current", 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("WatchPoll returned an error! '%s'", err)
+	}
+	defer poll.Close()
+
+	// SetString truncates before writing, so an empty value may show up first
+	expect := func(w *lnxns.VfsWatcher, value string) {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					t.Fatalf("watcher stopped early: %v", w.Err())
+				}
+				if ev.Value == value {
+					return
+				} else if ev.Value != "" {
+					t.Fatalf("watch event failed, Expected '%s', Got '%s'", value, ev.Value)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for '%s'", value)
+			}
+		}
+	}
+
+	vr.SetString("cgroup.events", "populated 1\nfrozen 0")
+	expect(inotify, "populated 1\nfrozen 0")
+
+	vr.SetString("memory.current", "8192")
+	expect(poll, "8192")
+
+	inotify.Close()
+	for _ = range inotify.Events {
+	}
+	if inotify.Err() != nil {
+		t.Fatalf("watcher stopped with an error: %s", inotify.Err())
+	}
+}
+
+// vim: ts=4 sw=4 noet tw=120 softtabstop=4